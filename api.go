@@ -2,12 +2,16 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type result[T any] struct {
@@ -26,19 +30,66 @@ type (
 		Mode   Mode    `json:"mode"`
 		Topics []Topic `json:"topics"`
 	}
+
+	resTag struct {
+		Tag    Tag     `json:"tag"`
+		Topics []Topic `json:"topics"`
+	}
+
+	resTagCount struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	resDiff struct {
+		From int    `json:"from"`
+		To   int    `json:"to"`
+		Diff string `json:"diff"`
+	}
+
+	resTrash struct {
+		Modes  []trash[Mode]  `json:"modes"`
+		Topics []trash[Topic] `json:"topics"`
+		Posts  []trash[Post]  `json:"posts"`
+	}
+
+	trash[T any] struct {
+		Item      T         `json:"item"`
+		DeletedAt time.Time `json:"deleted_at"`
+	}
+
+	resPending struct {
+		Id        int       `json:"id"`
+		TopicId   int       `json:"topic_id"`
+		Title     string    `json:"title"`
+		Author    string    `json:"author"`
+		Ip        string    `json:"ip"`
+		Content   string    `json:"content"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+
+	resSearch struct {
+		Id      int    `json:"id"`
+		Title   string `json:"title"`
+		ModeId  int    `json:"mode_id"`
+		Floor   int    `json:"floor"`
+		Snippet string `json:"snippet"`
+	}
 )
 
 type core interface {
 	create() error
 	update(interface{}) error
 	delete() error
+	restore() error
 }
 
 // api/search
 func getTopicsBySearch(c *gin.Context) {
-	uid := c.MustGet("uid").(int)
+	uid := viewerUid(c)
 	var urlquery struct {
-		Q string `form:"q"`
+		Q      string `form:"q"`
+		Offset int    `form:"offset" binding:"min=0"`
 	}
 	if err := c.ShouldBindQuery(&urlquery); err != nil {
 		responseError(c, err, 400, "payload error")
@@ -50,19 +101,19 @@ func getTopicsBySearch(c *gin.Context) {
 		return
 	}
 
-	var topics []Topic
-	err, code, msg := queryTopicsBySearch(&topics, uid, urlquery.Q)
+	var res []resSearch
+	err, code, msg := queryTopicsBySearch(&res, uid, urlquery.Q, urlquery.Offset)
 	if err != nil {
 		responseError(c, err, code, msg)
 		return
 	}
 
-	responseSuccess(c, topics)
+	responseSuccess(c, res)
 }
 
 // api/av
 func getTopics(c *gin.Context) {
-	uid := c.MustGet("uid").(int)
+	uid := viewerUid(c)
 	var urlquery struct {
 		Offset int `form:"offset" binding:"min=0"`
 	}
@@ -82,7 +133,7 @@ func getTopics(c *gin.Context) {
 
 // api/cv
 func getModes(c *gin.Context) {
-	uid := c.MustGet("uid").(int)
+	uid := viewerUid(c)
 
 	var modes []Mode
 	err := queryModes(&modes, uid)
@@ -96,7 +147,7 @@ func getModes(c *gin.Context) {
 
 // api/av/:aid
 func getTopicAndPosts(c *gin.Context) {
-	uid := c.MustGet("uid").(int)
+	uid := viewerUid(c)
 	aid, err := strconv.Atoi(c.Param("aid"))
 	if err != nil || aid <= 0 {
 		responseError(c, err, 404, "not found")
@@ -115,7 +166,7 @@ func getTopicAndPosts(c *gin.Context) {
 
 // api/cv/:cid
 func getTopicsByMode(c *gin.Context) {
-	uid := c.MustGet("uid").(int)
+	uid := viewerUid(c)
 	cid, err := strconv.Atoi(c.Param("cid"))
 	if err != nil || cid <= 0 {
 		responseError(c, err, 404, "not found")
@@ -138,57 +189,88 @@ func getTopicsByMode(c *gin.Context) {
 	responseSuccess(c, res)
 }
 
-func queryTopicsBySearch(dest *[]Topic, uid int, chars string) (error, int, string) {
-	var idx []int
-	err := db.Model(&Post{}).Where("content LIKE ?", "%"+chars+"%").Select("topic_id").Scan(&idx).Error
+// 原生 sql 中 uid == -1 时主题 t 的可见条件，与 queryTopics 一致
+var publicFilter = "AND " + publishedCond("t") + " AND t.mode_id <> 0 " +
+	"AND t.mode_id IN (SELECT id FROM modes WHERE pub = true AND deleted_at IS NULL)"
+
+// 草稿与定时主题仅对作者与 editor 以上角色可见
+func seesAllTopics(uid int) (bool, error) {
+	if uid == -1 {
+		return false, nil
+	}
+
+	var user User
+	err := db.Where("id = ?", uid).Select("role").Take(&user).Error
 	if err != nil {
-		return err, 500, "server error"
+		return false, err
 	}
 
-	if len(idx) == 0 {
-		dest = nil
-		return nil, 200, "success"
+	return roleLevel[user.Role] >= roleLevel[roleEditor], nil
+}
+
+// 登录用户可以看到非公开版块，但只能看到已发布的主题与自己的主题
+func topicFilter(uid int) (string, error) {
+	if uid == -1 {
+		return publicFilter, nil
 	}
 
-	idy := make([]int, 0, len(idx))
-	f := make(map[int]bool)
-	for _, x := range idx {
-		if !f[x] {
-			f[x] = true
-			idy = append(idy, x)
-		}
+	all, err := seesAllTopics(uid)
+	if err != nil || all {
+		return "", err
 	}
 
+	return fmt.Sprintf("AND (%s OR t.user_id = %d)", publishedCond("t"), uid), nil
+}
+
+// gorm 查询中 topicFilter 的等价条件
+func visibleTopics(tx *gorm.DB, uid int) (*gorm.DB, error) {
 	if uid == -1 {
-		subQuery := db.Model(&Mode{}).Select("id").Where("pub = ?", true)
-		err = db.Order("id DESC").Where("id IN (?)", idy).
-			Where("mode_id IN (?)", subQuery).Where("mode_id <> 0").
-			Select("id", "title", "mode_id").Find(dest).Error
-	} else {
-		err = db.Order("id DESC").Where("id IN (?)", idy).Select("id", "title", "mode_id").Find(dest).Error
+		modes := db.Model(&Mode{}).Select("id").Where("pub = ?", true)
+		return tx.Where(publishedCond("topics")).Where("mode_id IN (?)", modes).Where("mode_id <> 0"), nil
+	}
+
+	all, err := seesAllTopics(uid)
+	if err != nil || all {
+		return tx, err
+	}
+
+	return tx.Where(db.Where(publishedCond("topics")).Or("user_id = ?", uid)), nil
+}
+
+func queryTopicsBySearch(dest *[]resSearch, uid int, chars string, offset int) (error, int, string) {
+	filter, err := topicFilter(uid)
+	if err != nil {
+		return err, 500, "server error"
 	}
+
+	err = db.Raw(fmt.Sprintf(ftsQuery, filter), ftsMatch(chars), pageSize+1, offset).Scan(dest).Error
 	if err != nil {
 		return err, 500, "server error"
 	}
 
+	for i := range *dest {
+		(*dest)[i].Snippet = highlight((*dest)[i].Snippet)
+	}
+
+	if len(*dest) == pageSize+1 {
+		(*dest)[pageSize] = resSearch{Id: -1}
+	}
+
 	return nil, 200, "success"
 }
 
 func queryTopics(dest *[]Topic, uid int, offset int) error {
-	var err error
-	if uid == -1 {
-		subQuery := db.Model(&Mode{}).Select("id").Where("pub = ?", true)
-		err = db.Order("id DESC").Offset(offset).Limit(21).
-			Where("mode_id IN (?)", subQuery).Where("mode_id <> 0").Find(dest).Error
-	} else {
-		err = db.Order("id DESC").Offset(offset).Limit(21).Find(dest).Error
+	tx, err := visibleTopics(db.Order("id DESC").Offset(offset).Limit(pageSize+1), uid)
+	if err != nil {
+		return err
 	}
+	err = tx.Find(dest).Error
 	if err != nil {
 		return err
 	}
 
-	if len(*dest) == 21 {
-		(*dest)[20] = Topic{Id: -1}
+	if len(*dest) == pageSize+1 {
+		(*dest)[pageSize] = Topic{Id: -1}
 	}
 
 	return nil
@@ -219,8 +301,18 @@ func queryTopicAndPosts(dest *resAid, uid int, aid int) (error, int, string) {
 		return err, 500, "server error"
 	}
 
+	now := time.Now()
+	if uid != -1 && !topic.published(now) && topic.UserId != uid {
+		all, err := seesAllTopics(uid)
+		if err != nil {
+			return err, 500, "server error"
+		}
+		if !all {
+			return errors.New("access denied"), 404, "not found"
+		}
+	}
 	if uid == -1 {
-		if topic.ModeId == 0 {
+		if topic.ModeId == 0 || !topic.published(now) {
 			return errors.New("access denied"), 404, "not found"
 		}
 		mode := Mode{
@@ -236,10 +328,16 @@ func queryTopicAndPosts(dest *resAid, uid int, aid int) (error, int, string) {
 	}
 
 	var posts []Post
-	err = db.Order("floor").Where("topic_id = ?", aid).Find(&posts).Error
+	err = db.Order("floor").Where("topic_id = ?", aid).Where("status = ?", postApproved).Find(&posts).Error
+	if err != nil {
+		return err, 500, "server error"
+	}
+
+	tags, err := queryTopicTags([]int{aid})
 	if err != nil {
 		return err, 500, "server error"
 	}
+	topic.Tags = tags[aid]
 
 	dest.Topic = topic
 	dest.Posts = posts
@@ -265,13 +363,17 @@ func queryTopicsByMode(dest *resCid, uid int, cid int, offset int) (error, int,
 	}
 
 	var topics []Topic
-	err = db.Order("id DESC").Offset(offset).Limit(21).Where("mode_id = ?", cid).Find(&topics).Error
+	tx, err := visibleTopics(db.Order("id DESC").Offset(offset).Limit(pageSize+1).Where("mode_id = ?", cid), uid)
+	if err != nil {
+		return err, 500, "server error"
+	}
+	err = tx.Find(&topics).Error
 	if err != nil {
 		return err, 500, "server error"
 	}
 
-	if len(topics) == 21 {
-		topics[20] = Topic{Id: -1}
+	if len(topics) == pageSize+1 {
+		topics[pageSize] = Topic{Id: -1}
 	}
 
 	dest.Mode = mode
@@ -280,11 +382,93 @@ func queryTopicsByMode(dest *resCid, uid int, cid int, offset int) (error, int,
 	return nil, 200, ""
 }
 
+// api/tag
+func getTags(c *gin.Context) {
+	uid := viewerUid(c)
+
+	var tags []resTagCount
+	err := queryTags(&tags, uid)
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, tags)
+}
+
+// api/tag/:name
+func getTopicsByTag(c *gin.Context) {
+	uid := viewerUid(c)
+	var urlquery struct {
+		Offset int `form:"offset" binding:"min=0"`
+	}
+	if err := c.ShouldBindQuery(&urlquery); err != nil {
+		urlquery.Offset = 0
+	}
+
+	var res resTag
+	err, code, msg := queryTopicsByTag(&res, uid, c.Param("name"), urlquery.Offset)
+	if err != nil {
+		responseError(c, err, code, msg)
+		return
+	}
+
+	responseSuccess(c, res)
+}
+
+func queryTags(dest *[]resTagCount, uid int) error {
+	filter, err := topicFilter(uid)
+	if err != nil {
+		return err
+	}
+	var having string
+	if uid == -1 {
+		having = "HAVING count > 0"
+	}
+
+	return db.Raw(fmt.Sprintf(`SELECT g.name, COUNT(t.id) AS count FROM tags AS g
+		LEFT JOIN topic_tags AS r ON r.tag_id = g.id
+		LEFT JOIN topics AS t ON t.id = r.topic_id AND t.deleted_at IS NULL %s
+		GROUP BY g.id %s ORDER BY count DESC, g.name`, filter, having)).Scan(dest).Error
+}
+
+func queryTopicsByTag(dest *resTag, uid int, name string, offset int) (error, int, string) {
+	var tag Tag
+	err := db.Where("name = ?", name).Take(&tag).Error
+	if err != nil {
+		return err, 404, "not found"
+	}
+
+	var topics []Topic
+	subQuery := db.Model(&TopicTag{}).Select("topic_id").Where("tag_id = ?", tag.Id)
+	tx, err := visibleTopics(db.Order("id DESC").Offset(offset).Limit(pageSize+1).Where("id IN (?)", subQuery), uid)
+	if err != nil {
+		return err, 500, "server error"
+	}
+	err = tx.Find(&topics).Error
+	if err != nil {
+		return err, 500, "server error"
+	}
+	if uid == -1 && offset == 0 && len(topics) == 0 {
+		return errors.New("access denied"), 404, "not found"
+	}
+
+	if len(topics) == pageSize+1 {
+		topics[pageSize] = Topic{Id: -1}
+	}
+
+	dest.Tag = tag
+	dest.Topics = topics
+
+	return nil, 200, ""
+}
+
 // api/cv/create
 func createMode(c *gin.Context) {
 	var payload struct {
-		Name string `json:"name" binding:"required"`
-		Pub  bool   `json:"pub"  binding:"required"`
+		Name  string `json:"name"  binding:"required"`
+		Pub   bool   `json:"pub"   binding:"required"`
+		Guest bool   `json:"guest"`
 	}
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		responseError(c, err, 400, "payload error")
@@ -292,8 +476,9 @@ func createMode(c *gin.Context) {
 	}
 
 	obj := Mode{
-		Name: payload.Name,
-		Pub:  payload.Pub,
+		Name:  payload.Name,
+		Pub:   payload.Pub,
+		Guest: payload.Guest,
 	}
 
 	err := coreCreate(&obj)
@@ -331,15 +516,16 @@ func deleteMode(c *gin.Context) {
 // api/cv/update
 func updateMode(c *gin.Context) {
 	var payload struct {
-		Id   int     `json:"id" binding:"required"`
-		Name *string `json:"name"`
-		Pub  *bool   `json:"pub"`
+		Id    int     `json:"id" binding:"required"`
+		Name  *string `json:"name"`
+		Pub   *bool   `json:"pub"`
+		Guest *bool   `json:"guest"`
 	}
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		responseError(c, err, 400, "payload error")
 		return
 	}
-	if payload.Name == nil && payload.Pub == nil {
+	if payload.Name == nil && payload.Pub == nil && payload.Guest == nil {
 		responseError(c, errors.New("missing value"), 400, "payload error")
 		return
 	}
@@ -360,18 +546,35 @@ func updateMode(c *gin.Context) {
 // api/av/create
 func createTopic(c *gin.Context) {
 	var payload struct {
-		Title   string `json:"title"    binding:"required"`
-		ModeId  int    `json:"mode_id" binding:"required"`
-		Content string `json:"content"  binding:"required"`
+		Title     string     `json:"title"      binding:"required"`
+		ModeId    int        `json:"mode_id"    binding:"required"`
+		Content   string     `json:"content"    binding:"required"`
+		Status    string     `json:"status"     binding:"omitempty,oneof=draft scheduled published"`
+		PublishAt *time.Time `json:"publish_at"`
+		Tags      []string   `json:"tags"`
 	}
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		responseError(c, err, 400, "payload error")
 		return
 	}
+	if payload.Status == "" {
+		payload.Status = statusPublished
+	}
+	if err := checkSchedule(payload.Status, &payload.PublishAt); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+	if err := checkTags(cleanTags(payload.Tags)); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
 
 	obj := Topic{
-		Title:  payload.Title,
-		ModeId: payload.ModeId,
+		Title:     payload.Title,
+		ModeId:    payload.ModeId,
+		UserId:    c.MustGet("uid").(int),
+		Status:    payload.Status,
+		PublishAt: payload.PublishAt,
 	}
 
 	err := coreCreate(&obj)
@@ -380,8 +583,15 @@ func createTopic(c *gin.Context) {
 		return
 	}
 
+	obj.Tags, err = setTopicTags(obj.Id, payload.Tags)
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
 	data := Post{
 		TopicId: obj.Id,
+		UserId:  obj.UserId,
 		Content: payload.Content,
 	}
 
@@ -423,29 +633,75 @@ func deleteTopic(c *gin.Context) {
 // api/av/update
 func updateTopic(c *gin.Context) {
 	var payload struct {
-		Id     int     `json:"id" binding:"required"`
-		Title  *string `json:"title"`
-		ModeId *int    `json:"mode_id"`
+		Id        int        `json:"id" binding:"required"`
+		Title     *string    `json:"title"`
+		ModeId    *int       `json:"mode_id"`
+		Status    *string    `json:"status"     binding:"omitempty,oneof=draft scheduled published"`
+		PublishAt *time.Time `json:"publish_at"`
+		Tags      *[]string  `json:"tags" gorm:"-"`
 	}
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		responseError(c, err, 400, "payload error")
 		return
 	}
 
-	if payload.Title == nil && payload.ModeId == nil {
+	fields := payload.Title != nil || payload.ModeId != nil || payload.Status != nil || payload.PublishAt != nil
+	if !fields && payload.Tags == nil {
 		responseError(c, errors.New("missing value"), 400, "payload error")
 		return
 	}
-
+	if payload.Tags != nil {
+		if err := checkTags(cleanTags(*payload.Tags)); err != nil {
+			responseError(c, err, 400, "payload error")
+			return
+		}
+	}
 	obj := Topic{
 		Id: payload.Id,
 	}
+	err := obj.stat("id")
+	if err != nil {
+		responseError(c, err, 404, "not found")
+		return
+	}
 
-	err := coreUpdate(&obj, payload)
+	// 与已保存的值合并后校验，只修改 status 时沿用原有的 publish_at
+	var stored Topic
+	err = db.Where("id = ?", payload.Id).Select("status", "publish_at").Take(&stored).Error
 	if err != nil {
 		responseError(c, err, 500, "server error")
 		return
 	}
+	status, publishAt := stored.Status, stored.PublishAt
+	if payload.Status != nil {
+		status = *payload.Status
+	}
+	if payload.PublishAt != nil {
+		publishAt = payload.PublishAt
+	}
+	if err = checkSchedule(status, &publishAt); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+	if payload.PublishAt != nil {
+		payload.PublishAt = publishAt
+	}
+
+	if fields {
+		err = coreUpdate(&obj, payload)
+		if err != nil {
+			responseError(c, err, 500, "server error")
+			return
+		}
+	}
+
+	if payload.Tags != nil {
+		obj.Tags, err = setTopicTags(obj.Id, *payload.Tags)
+		if err != nil {
+			responseError(c, err, 500, "server error")
+			return
+		}
+	}
 
 	responseSuccess(c, obj)
 }
@@ -463,6 +719,7 @@ func createPost(c *gin.Context) {
 
 	obj := Post{
 		TopicId: payload.TopicId,
+		UserId:  c.MustGet("uid").(int),
 		Content: payload.Content,
 	}
 
@@ -518,6 +775,10 @@ func updatePost(c *gin.Context) {
 	}
 
 	err := coreUpdate(&obj, payload)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		responseError(c, err, 404, "not found")
+		return
+	}
 	if err != nil {
 		responseError(c, err, 500, "server error")
 		return
@@ -526,94 +787,1100 @@ func updatePost(c *gin.Context) {
 	responseSuccess(c, obj)
 }
 
-// api/space
-func getAuthStat(c *gin.Context) {
-	uid := c.MustGet("uid").(int)
-	var stat bool
+// api/fl/token
+func getFormToken(c *gin.Context) {
+	var urlquery struct {
+		TopicId int `form:"topic_id" binding:"required"`
+	}
+	if err := c.ShouldBindQuery(&urlquery); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
 
-	if uid == 1 {
-		stat = true
+	err, code, msg := checkGuestTopic(urlquery.TopicId)
+	if err != nil {
+		responseError(c, err, code, msg)
+		return
 	}
 
-	responseSuccess(c, stat)
+	token, err := encodeFormToken(urlquery.TopicId, time.Now())
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, token)
 }
 
-// api/login
-func verifyAuthKey(c *gin.Context) {
+// api/fl/guest
+func createGuestPost(c *gin.Context) {
 	var payload struct {
-		Password string `json:"password" binding:"required"`
+		TopicId int    `json:"topic_id" binding:"required"`
+		Author  string `json:"author"   binding:"max=32"`
+		Content string `json:"content"  binding:"required,max=4000"`
+		Token   string `json:"token"`
+		Website string `json:"website"` // 蜜罐字段，正常表单不可见
 	}
-	err := c.ShouldBindJSON(&payload)
-	if err != nil {
+	if err := c.ShouldBindJSON(&payload); err != nil {
 		responseError(c, err, 400, "payload error")
 		return
 	}
 
-	hash, err := getAuthHash()
+	ip := c.ClientIP()
+	now := time.Now()
+	if wait, ok := guestLimiter.allow(ip, now); !ok {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		responseError(c, errors.New("rate limited: "+ip), 429, "too many requests")
+		return
+	}
+
+	err, code, msg := checkGuestTopic(payload.TopicId)
 	if err != nil {
-		responseError(c, err, 500, "server error")
+		responseError(c, err, code, msg)
 		return
 	}
 
-	ok := verifyPassword(hash, payload.Password)
-	if !ok {
-		responseError(c, errors.New("password error"), 401, "password error")
+	sub := submission{
+		TopicId:  payload.TopicId,
+		Author:   strings.TrimSpace(payload.Author),
+		Content:  payload.Content,
+		Ip:       ip,
+		Honeypot: payload.Website,
+		Token:    payload.Token,
+		Now:      now,
+	}
+	if reason := checkSpam(&sub); reason != "" {
+		spam := Spam{
+			TopicId: sub.TopicId,
+			Author:  sub.Author,
+			Content: sub.Content,
+			Ip:      sub.Ip,
+			Reason:  reason,
+		}
+		err = db.Create(&spam).Error
+		if err != nil {
+			responseError(c, err, 500, "server error")
+			return
+		}
+
+		responseError(c, errors.New("spam: "+reason), 403, "rejected")
 		return
 	}
-	token, err := encodeToken()
+
+	obj := Post{
+		TopicId: sub.TopicId,
+		Author:  sub.Author,
+		Ip:      sub.Ip,
+		Status:  postPending,
+		Content: sub.Content,
+	}
+
+	err = coreCreate(&obj)
 	if err != nil {
 		responseError(c, err, 500, "server error")
 		return
 	}
 
-	responseSuccess(c, token)
+	responseSuccess(c, obj)
 }
 
-// api/auth/change
-func changeAuthKey(c *gin.Context) {
-	var payload struct {
-		Password string `json:"password" binding:"required"`
+// 游客只能回复公开版块中已发布且开启游客回复的主题
+func checkGuestTopic(tid int) (error, int, string) {
+	var count int64
+	err := db.Table("topics AS t").Where("t.id = ?", tid).Where("t.deleted_at IS NULL").
+		Where(publishedCond("t")).Where("t.mode_id <> 0").
+		Where("t.mode_id IN (?)", db.Model(&Mode{}).Select("id").Where("pub = ?", true).Where("guest = ?", true)).
+		Count(&count).Error
+	if err != nil {
+		return err, 500, "server error"
 	}
-	err := c.ShouldBindJSON(&payload)
+	if count == 0 {
+		return errors.New("guest reply disabled"), 404, "not found"
+	}
+
+	return nil, 200, ""
+}
+
+// api/moderation
+func getPendingPosts(c *gin.Context) {
+	var res []resPending
+	err := db.Model(&Post{}).Select("posts.id", "posts.topic_id", "topics.title", "posts.author",
+		"posts.ip", "posts.content", "posts.updated_at").Joins("JOIN topics ON topics.id = posts.topic_id").
+		Where("posts.status = ?", postPending).Order("posts.id").Scan(&res).Error
 	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, res)
+}
+
+// api/moderation/approve
+func approvePendingPosts(c *gin.Context) {
+	var payload struct {
+		Ids []int `json:"ids" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
 		responseError(c, err, 400, "payload error")
 		return
 	}
 
-	err = addAuthHash(payload.Password)
+	posts, err := approvePosts(payload.Ids)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		responseError(c, err, 404, "not found")
+		return
+	}
 	if err != nil {
 		responseError(c, err, 500, "server error")
 		return
 	}
 
-	responseSuccess(c, (*struct{})(nil))
+	responseSuccess(c, posts)
 }
 
-func authMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		token := c.Request.Header.Get("Authorization")
-		ok := decodeToken(token)
-		if ok {
-			c.Set("uid", 1)
-		} else {
-			c.Set("uid", -1)
-		}
-		c.Next()
+// api/moderation/reject
+func rejectPendingPosts(c *gin.Context) {
+	var payload struct {
+		Ids []int `json:"ids" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
 	}
-}
 
-func protectMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		uid := c.MustGet("uid").(int)
-		if uid == -1 {
-			c.AbortWithStatusJSON(403, result[*struct{}]{
-				Code: 403,
-				Msg:  "access denied",
-				Data: nil,
-			})
-			return
-		}
-		c.Next()
+	n, err := rejectPosts(payload.Ids)
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, n)
+}
+
+// api/moderation/spam
+func getSpam(c *gin.Context) {
+	var res []Spam
+	err := db.Order("id DESC").Find(&res).Error
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, res)
+}
+
+// api/moderation/spam/release
+func releaseCaughtSpam(c *gin.Context) {
+	var payload struct {
+		Ids []int `json:"ids" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	posts, err := releaseSpam(payload.Ids)
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, posts)
+}
+
+// api/moderation/spam/delete
+func deleteCaughtSpam(c *gin.Context) {
+	var payload struct {
+		Ids []int `json:"ids" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	res := db.Where("id IN ?", payload.Ids).Delete(&Spam{})
+	if res.Error != nil {
+		responseError(c, res.Error, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, res.RowsAffected)
+}
+
+// api/upload
+func uploadFile(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxFileSize+maxFormOverhead)
+
+	fh, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		responseError(c, err, 413, "file too large")
+		return
+	}
+	if err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+	if fh.Size > maxFileSize {
+		responseError(c, errFileTooLarge, 413, "file too large")
+		return
+	}
+
+	src, err := fh.Open()
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+	defer src.Close()
+
+	f, err := saveFile(src, fh.Filename)
+	if errors.Is(err, errFileTooLarge) {
+		responseError(c, err, 413, "file too large")
+		return
+	}
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, f)
+}
+
+// api/fl/revision
+func getPostRevisions(c *gin.Context) {
+	var urlquery struct {
+		TopicId int `form:"topic_id" binding:"required"`
+		Floor   int `form:"floor"    binding:"required"`
+	}
+	if err := c.ShouldBindQuery(&urlquery); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	var revs []PostRevision
+	err := db.Order("id DESC").Where("topic_id = ?", urlquery.TopicId).Where("floor = ?", urlquery.Floor).
+		Find(&revs).Error
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, revs)
+}
+
+// api/fl/diff
+func getPostDiff(c *gin.Context) {
+	var urlquery struct {
+		TopicId int `form:"topic_id" binding:"required"`
+		Floor   int `form:"floor"    binding:"required"`
+		From    int `form:"from"     binding:"min=0"`
+		To      int `form:"to"       binding:"min=0"`
+	}
+	if err := c.ShouldBindQuery(&urlquery); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	from, err, code, msg := queryRevisionContent(urlquery.TopicId, urlquery.Floor, urlquery.From)
+	if err != nil {
+		responseError(c, err, code, msg)
+		return
+	}
+	to, err, code, msg := queryRevisionContent(urlquery.TopicId, urlquery.Floor, urlquery.To)
+	if err != nil {
+		responseError(c, err, code, msg)
+		return
+	}
+
+	responseSuccess(c, resDiff{
+		From: urlquery.From,
+		To:   urlquery.To,
+		Diff: unifiedDiff(from, to, revisionName(urlquery.From), revisionName(urlquery.To)),
+	})
+}
+
+// api/fl/restore
+func restorePost(c *gin.Context) {
+	var payload struct {
+		TopicId int `json:"topic_id" binding:"required"`
+		Floor   int `json:"floor"    binding:"required"`
+		Id      int `json:"id"       binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	content, err, code, msg := queryRevisionContent(payload.TopicId, payload.Floor, payload.Id)
+	if err != nil {
+		responseError(c, err, code, msg)
+		return
+	}
+
+	obj := Post{
+		TopicId: payload.TopicId,
+		Floor:   payload.Floor,
+	}
+
+	err = coreUpdate(&obj, struct{ Content string }{content})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		responseError(c, err, 404, "not found")
+		return
+	}
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, obj)
+}
+
+// id 为 0 时取楼层当前内容
+func queryRevisionContent(tid int, floor int, id int) (string, error, int, string) {
+	var err error
+	var content string
+	if id == 0 {
+		var post Post
+		err = db.Where("topic_id = ?", tid).Where("floor = ?", floor).Select("content").Take(&post).Error
+		content = post.Content
+	} else {
+		var rev PostRevision
+		err = db.Where("id = ?", id).Where("topic_id = ?", tid).Where("floor = ?", floor).
+			Select("content").Take(&rev).Error
+		content = rev.Content
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err, 404, "not found"
+	}
+	if err != nil {
+		return "", err, 500, "server error"
+	}
+
+	return content, nil, 200, ""
+}
+
+func revisionName(id int) string {
+	if id == 0 {
+		return "current"
+	}
+
+	return "revision " + strconv.Itoa(id)
+}
+
+// api/trash
+func getTrash(c *gin.Context) {
+	var res resTrash
+	err := queryTrash(&res)
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, res)
+}
+
+func queryTrash(dest *resTrash) error {
+	var modes []Mode
+	err := db.Unscoped().Order("deleted_at DESC").Where("deleted_at IS NOT NULL").Find(&modes).Error
+	if err != nil {
+		return err
+	}
+	for _, m := range modes {
+		dest.Modes = append(dest.Modes, trash[Mode]{Item: m, DeletedAt: m.DeletedAt.Time})
+	}
+
+	var topics []Topic
+	err = db.Unscoped().Order("deleted_at DESC").Where("deleted_at IS NOT NULL").Find(&topics).Error
+	if err != nil {
+		return err
+	}
+	for _, t := range topics {
+		dest.Topics = append(dest.Topics, trash[Topic]{Item: t, DeletedAt: t.DeletedAt.Time})
+	}
+
+	var posts []Post
+	err = db.Unscoped().Order("deleted_at DESC").Where("deleted_at IS NOT NULL").Find(&posts).Error
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		dest.Posts = append(dest.Posts, trash[Post]{Item: p, DeletedAt: p.DeletedAt.Time})
+	}
+
+	return nil
+}
+
+// api/trash/cv/restore
+func restoreMode(c *gin.Context) {
+	var payload struct {
+		Id int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	obj := Mode{
+		Id: payload.Id,
+	}
+
+	err := coreRestore(&obj)
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, obj)
+}
+
+// api/trash/av/restore
+func restoreTopic(c *gin.Context) {
+	var payload struct {
+		Id int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	obj := Topic{
+		Id: payload.Id,
+	}
+
+	err := coreRestore(&obj)
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, obj)
+}
+
+// api/trash/fl/restore
+func restoreDeletedPost(c *gin.Context) {
+	var payload struct {
+		TopicId int `json:"topic_id" binding:"required"`
+		Floor   int `json:"floor"    binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	obj := Post{
+		TopicId: payload.TopicId,
+		Floor:   payload.Floor,
+	}
+
+	err := coreRestore(&obj)
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, obj)
+}
+
+// api/trash/purge
+func purgeDeleted(c *gin.Context) {
+	var payload struct {
+		Days int `json:"days" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	err := purgeTrash(time.Now().AddDate(0, 0, -payload.Days))
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, (*struct{})(nil))
+}
+
+// api/space
+func getAuthStat(c *gin.Context) {
+	uid := c.MustGet("uid").(int)
+	var stat bool
+
+	if uid != -1 {
+		stat = true
+	}
+
+	responseSuccess(c, stat)
+}
+
+// api/login
+func verifyAuthKey(c *gin.Context) {
+	var payload struct {
+		Username string `json:"username"`
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code"` // 两步验证码或恢复码
+	}
+	err := c.ShouldBindJSON(&payload)
+	if err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+	if payload.Username == "" {
+		payload.Username = defaultUsername
+	}
+
+	ip := c.ClientIP()
+	now := time.Now()
+	if wait := max(guard.wait(ip, now), guard.globalWait(ip, now)); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		responseError(c, fmt.Errorf("login locked: ip=%s user=%q", ip, payload.Username),
+			429, "too many requests")
+		return
+	}
+
+	var user User
+	err = db.Where("username = ?", payload.Username).Take(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	hash := user.Hash
+	if err != nil {
+		hash = dummyHash
+	}
+	ok := verifyPassword(hash, payload.Password) && err == nil
+	if !ok {
+		n := guard.fail(ip, time.Now())
+		responseError(c, fmt.Errorf("login failed: ip=%s user=%q failures=%d", ip, payload.Username, n),
+			401, "password error")
+		return
+	}
+
+	err = checkSecondFactor(&user, payload.Code)
+	if errors.Is(err, errTotpRequired) {
+		responseError(c, err, 401, "totp required")
+		return
+	}
+	if errors.Is(err, errTotpInvalid) {
+		n := guard.fail(ip, time.Now())
+		responseError(c, fmt.Errorf("login totp failed: ip=%s user=%q failures=%d", ip, payload.Username, n),
+			401, "totp invalid")
+		return
+	}
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+	guard.succeed(ip, time.Now())
+
+	token, err := encodeToken(user.Id, ip, c.Request.UserAgent())
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, token)
+}
+
+// api/auth/change
+func changeAuthKey(c *gin.Context) {
+	uid := c.MustGet("uid").(int)
+	var payload struct {
+		Password string `json:"password" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&payload)
+	if err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	err = setUserPassword(uid, payload.Password)
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	// 修改密码吊销全部会话，为当前客户端重新签发
+	token, err := encodeToken(uid, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, token)
+}
+
+// api/auth/logout
+func logout(c *gin.Context) {
+	err := db.Where("id = ?", c.MustGet("jti").(string)).Delete(&Session{}).Error
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, (*struct{})(nil))
+}
+
+// api/auth/session
+func getSessions(c *gin.Context) {
+	var sessions []Session
+	err := db.Where("user_id = ?", c.MustGet("uid").(int)).Where("expires_at > ?", time.Now()).
+		Order("created_at DESC").Find(&sessions).Error
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	jti := c.MustGet("jti").(string)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].Id == jti
+	}
+
+	responseSuccess(c, sessions)
+}
+
+// api/auth/session/revoke
+func revokeSession(c *gin.Context) {
+	var payload struct {
+		Id string `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	res := db.Where("id = ?", payload.Id).Where("user_id = ?", c.MustGet("uid").(int)).Delete(&Session{})
+	if res.Error != nil {
+		responseError(c, res.Error, 500, "server error")
+		return
+	}
+	if res.RowsAffected == 0 {
+		responseError(c, errors.New("session not found"), 404, "not found")
+		return
+	}
+
+	responseSuccess(c, (*struct{})(nil))
+}
+
+// api/auth/totp/setup
+func setupTwoFactor(c *gin.Context) {
+	secret, uri, err := setupTotp(c.MustGet("uid").(int))
+	if err != nil {
+		responseError(c, err, 400, "totp error")
+		return
+	}
+
+	responseSuccess(c, gin.H{
+		"secret": secret,
+		"uri":    uri,
+	})
+}
+
+// api/auth/totp/enable
+func enableTwoFactor(c *gin.Context) {
+	var payload struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	codes, err := enableTotp(c.MustGet("uid").(int), strings.TrimSpace(payload.Code))
+	if errors.Is(err, errTotpInvalid) {
+		responseError(c, err, 400, "totp invalid")
+		return
+	}
+	if err != nil {
+		responseError(c, err, 400, "totp error")
+		return
+	}
+
+	responseSuccess(c, codes)
+}
+
+// api/auth/totp/disable
+func disableTwoFactor(c *gin.Context) {
+	uid := c.MustGet("uid").(int)
+	var payload struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	var user User
+	err := db.Where("id = ?", uid).Select("hash").Take(&user).Error
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+	if !verifyPassword(user.Hash, payload.Password) {
+		responseError(c, errors.New("password error"), 401, "password error")
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return disableTotp(tx, uid)
+	})
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, (*struct{})(nil))
+}
+
+// api/auth/token
+func getApiTokens(c *gin.Context) {
+	var tokens []ApiToken
+	err := db.Where("user_id = ?", c.MustGet("uid").(int)).Order("id DESC").Find(&tokens).Error
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, tokens)
+}
+
+// api/auth/token/create
+func createToken(c *gin.Context) {
+	var payload struct {
+		Name      string     `json:"name"   binding:"required,max=64"`
+		Scopes    []string   `json:"scopes" binding:"required,min=1"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		responseError(c, errors.New("expires_at in the past"), 400, "payload error")
+		return
+	}
+
+	obj := ApiToken{
+		UserId:    c.MustGet("uid").(int),
+		Name:      payload.Name,
+		ExpiresAt: payload.ExpiresAt,
+	}
+
+	token, err := createApiToken(&obj, c.GetString("role"), payload.Scopes)
+	if err != nil && obj.Hash == "" {
+		responseError(c, err, 400, "scope error")
+		return
+	}
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, gin.H{
+		"token": token,
+		"info":  obj,
+	})
+}
+
+// api/auth/token/delete
+func deleteToken(c *gin.Context) {
+	var payload struct {
+		Id int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	res := db.Where("id = ?", payload.Id).Where("user_id = ?", c.MustGet("uid").(int)).Delete(&ApiToken{})
+	if res.Error != nil {
+		responseError(c, res.Error, 500, "server error")
+		return
+	}
+	if res.RowsAffected == 0 {
+		responseError(c, errors.New("token not found"), 404, "not found")
+		return
+	}
+
+	responseSuccess(c, (*struct{})(nil))
+}
+
+// api/auth/rotate
+func rotateKey(c *gin.Context) {
+	kid, err := rotateHmacKey()
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, kid)
+}
+
+// api/backup
+func getBackupStatus(c *gin.Context) {
+	responseSuccess(c, getBackupState())
+}
+
+// api/export
+func exportBlog(c *gin.Context) {
+	d, err := collectExport()
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	now := time.Now()
+	c.Header("Content-Type", "application/gzip")
+	c.Header("Content-Disposition", `attachment; filename="sealog-export-`+now.Format("20060102-150405")+`.tar.gz"`)
+
+	// 响应头已发出，出错时只能中断下载
+	_, err = writeExportArchive(c.Writer, d, now)
+	if err != nil {
+		log.Println("error:", err)
+		c.Abort()
+	}
+}
+
+// api/user/me
+func getCurrentUser(c *gin.Context) {
+	uid := c.MustGet("uid").(int)
+
+	var user User
+	err := db.Where("id = ?", uid).Take(&user).Error
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, user)
+}
+
+// api/user
+func getUsers(c *gin.Context) {
+	var users []User
+	err := db.Order("id").Find(&users).Error
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, users)
+}
+
+// api/user/create
+func createUser(c *gin.Context) {
+	var payload struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+		Role     string `json:"role"     binding:"required,oneof=owner editor reader"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	hash, err := cryptoPassword(payload.Password)
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	user := User{
+		Username: payload.Username,
+		Hash:     hash,
+		Role:     payload.Role,
+	}
+
+	err = db.Create(&user).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		responseError(c, err, 400, "user exists")
+		return
+	}
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, user)
+}
+
+// api/user/update
+func updateUser(c *gin.Context) {
+	var payload struct {
+		Id       int     `json:"id"       binding:"required"`
+		Password *string `json:"password" binding:"omitempty,min=1"`
+		Role     *string `json:"role"     binding:"omitempty,oneof=owner editor reader"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+	if payload.Password == nil && payload.Role == nil {
+		responseError(c, errors.New("missing value"), 400, "payload error")
+		return
+	}
+
+	user := User{
+		Id: payload.Id,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("id = ?", user.Id).Take(&user).Error
+		if err != nil {
+			return err
+		}
+
+		data := make(map[string]interface{})
+		if payload.Password != nil {
+			data["hash"], err = cryptoPassword(*payload.Password)
+			if err != nil {
+				return err
+			}
+			err = revokeSessions(tx, user.Id)
+			if err != nil {
+				return err
+			}
+		}
+		if payload.Role != nil {
+			if user.Role == roleOwner && *payload.Role != roleOwner {
+				err = checkLastOwner(tx)
+				if err != nil {
+					return err
+				}
+			}
+			data["role"] = *payload.Role
+		}
+
+		return tx.Model(&user).Updates(data).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		responseError(c, err, 404, "not found")
+		return
+	}
+	if errors.Is(err, errLastOwner) {
+		responseError(c, err, 400, "last owner")
+		return
+	}
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, user)
+}
+
+// api/user/delete
+func deleteUser(c *gin.Context) {
+	var payload struct {
+		Id int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		responseError(c, err, 400, "payload error")
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		err := tx.Where("id = ?", payload.Id).Take(&user).Error
+		if err != nil {
+			return err
+		}
+
+		if user.Role == roleOwner {
+			err = checkLastOwner(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = revokeSessions(tx, user.Id)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("user_id = ?", user.Id).Delete(&ApiToken{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&user).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		responseError(c, err, 404, "not found")
+		return
+	}
+	if errors.Is(err, errLastOwner) {
+		responseError(c, err, 400, "last owner")
+		return
+	}
+	if err != nil {
+		responseError(c, err, 500, "server error")
+		return
+	}
+
+	responseSuccess(c, (*struct{})(nil))
+}
+
+var errLastOwner = errors.New("cannot remove the last owner")
+
+// 至少保留一个 owner
+func checkLastOwner(tx *gorm.DB) error {
+	var n int64
+	err := tx.Model(&User{}).Where("role = ?", roleOwner).Count(&n).Error
+	if err != nil {
+		return err
+	}
+	if n <= 1 {
+		return errLastOwner
+	}
+
+	return nil
+}
+
+func authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, role, jti := -1, "", ""
+
+		token := c.Request.Header.Get("Authorization")
+		if strings.HasPrefix(token, apiTokenPrefix) {
+			id, scopes, ok := verifyApiToken(token, c.ClientIP())
+			if ok {
+				var user User
+				err := db.Where("id = ?", id).Select("id", "role").Take(&user).Error
+				if err == nil {
+					uid, role = user.Id, user.Role
+					c.Set("scopes", scopes)
+				}
+			}
+		} else if id, sid, ok := decodeToken(token); ok {
+			var user User
+			err := db.Where("id = ?", id).Select("id", "role").Take(&user).Error
+			if err == nil {
+				uid, role, jti = user.Id, user.Role, sid
+			}
+		}
+
+		c.Set("uid", uid)
+		c.Set("role", role)
+		c.Set("jti", jti)
+		c.Next()
+	}
+}
+
+// 未登录或角色低于 role 时拒绝访问
+// 访问令牌须具备 scopes 之一，未列出 scopes 的路由仅限登录会话
+func protectMiddleware(role string, scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.MustGet("uid").(int)
+		if uid == -1 || roleLevel[c.GetString("role")] < roleLevel[role] || !hasScope(c, scopes...) {
+			c.AbortWithStatusJSON(403, result[*struct{}]{
+				Code: 403,
+				Msg:  "access denied",
+				Data: nil,
+			})
+			return
+		}
+		c.Next()
 	}
 }
 
@@ -646,3 +1913,7 @@ func coreUpdate(obj core, data interface{}) error {
 func coreDelete(obj core) error {
 	return obj.delete()
 }
+
+func coreRestore(obj core) error {
+	return obj.restore()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 游客只能回复公开且开启游客回复的版块中已发布的主题
+func TestCheckGuestTopic(t *testing.T) {
+	newGuestTopic := func(pub bool, guest bool, status string) int {
+		mode := Mode{Name: t.Name(), Pub: pub, Guest: guest}
+		err := mode.create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		topic := Topic{Title: t.Name(), ModeId: mode.Id, Status: status}
+		err = topic.create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return topic.Id
+	}
+
+	tests := []struct {
+		name string
+		tid  int
+		want int
+	}{
+		{"open", newGuestTopic(true, true, statusPublished), 200},
+		{"guest disabled", newGuestTopic(true, false, statusPublished), 404},
+		{"private mode", newGuestTopic(false, true, statusPublished), 404},
+		{"draft", newGuestTopic(true, true, statusDraft), 404},
+		{"missing", -1, 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, code, _ := checkGuestTopic(tt.tid)
+			if code != tt.want {
+				t.Errorf("code %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+// 游客回复进入待审核队列，被拦截的提交记入 spam
+func TestCreateGuestPost(t *testing.T) {
+	err := db.AutoMigrate(&Spam{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := spamCheckers
+	t.Cleanup(func() {
+		spamCheckers = saved
+		guestLimiter = newRateLimiter(guestRateLimit, guestRateWindow)
+	})
+	spamCheckers = []spamChecker{honeypotChecker{}, wordChecker{words: []string{"casino"}}}
+
+	mode := Mode{Name: t.Name(), Pub: true, Guest: true}
+	err = mode.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic := Topic{Title: t.Name(), ModeId: mode.Id}
+	err = topic.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tid := strconv.Itoa(topic.Id)
+
+	tests := []struct {
+		name    string
+		body    string
+		want    int
+		pending int64
+		spam    int64
+	}{
+		{"accepted", `{"topic_id":` + tid + `,"author":"a","content":"hello"}`, 200, 1, 0},
+		{"honeypot", `{"topic_id":` + tid + `,"content":"hello","website":"x"}`, 403, 0, 1},
+		{"banned word", `{"topic_id":` + tid + `,"content":"casino"}`, 403, 0, 1},
+		{"no content", `{"topic_id":` + tid + `}`, 400, 0, 0},
+		{"closed topic", `{"topic_id":-1,"content":"hello"}`, 404, 0, 0},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guestLimiter = newRateLimiter(guestRateLimit, guestRateWindow)
+			var pending, spam int64
+			db.Model(&Post{}).Where("topic_id = ? AND status = ?", topic.Id, postPending).Count(&pending)
+			db.Model(&Spam{}).Where("topic_id = ?", topic.Id).Count(&spam)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/api/fl/guest", strings.NewReader(tt.body))
+			c.Request.RemoteAddr = "192.0.2." + strconv.Itoa(i) + ":1"
+			c.Request.Header.Set("Content-Type", "application/json")
+			createGuestPost(c)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			var pending2, spam2 int64
+			db.Model(&Post{}).Where("topic_id = ? AND status = ?", topic.Id, postPending).Count(&pending2)
+			db.Model(&Spam{}).Where("topic_id = ?", topic.Id).Count(&spam2)
+			if pending2-pending != tt.pending || spam2-spam != tt.spam {
+				t.Errorf("%d new pending, %d new spam", pending2-pending, spam2-spam)
+			}
+		})
+	}
+}
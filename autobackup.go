@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 自动备份文件名 sealog-20060102-150405.db，按本地时间命名
+const (
+	snapshotPrefix = "sealog-"
+	snapshotLayout = "20060102-150405"
+)
+
+// 失败后的重试间隔，不超过备份间隔
+const snapshotRetry = time.Hour
+
+// backupState 自动备份状态
+type backupState struct {
+	Enabled   bool       `json:"enabled"`
+	Interval  string     `json:"interval"`
+	Daily     int        `json:"daily"`
+	Weekly    int        `json:"weekly"`
+	Snapshots int        `json:"snapshots"`
+	LastAt    *time.Time `json:"last_at"`    // 最近一次尝试
+	LastOkAt  *time.Time `json:"last_ok_at"` // 最近一次成功
+	LastFile  string     `json:"last_file"`
+	LastSize  int64      `json:"last_size"`
+	LastError string     `json:"last_error"`
+	NextAt    *time.Time `json:"next_at"`
+}
+
+var (
+	backupMu     sync.Mutex
+	backupStatus backupState
+)
+
+func getBackupState() backupState {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	return backupStatus
+}
+
+// snapshot 备份目录中的快照
+type snapshot struct {
+	name string
+	at   time.Time
+}
+
+// 按间隔将 data.db 快照写入 rootfs/backups，ctx 取消时退出
+func runBackups(ctx context.Context, wg *sync.WaitGroup, cfg *config) {
+	defer wg.Done()
+
+	backupMu.Lock()
+	backupStatus = backupState{
+		Enabled:  cfg.backupInterval > 0,
+		Interval: cfg.backupInterval.String(),
+		Daily:    cfg.backupDaily,
+		Weekly:   cfg.backupWeekly,
+	}
+	backupMu.Unlock()
+
+	if cfg.backupInterval <= 0 {
+		return
+	}
+
+	dir := filepath.Join(cfg.rootfs, "backups")
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		log.Println("error: backup:", err)
+		return
+	}
+
+	// 重启后从最近一次快照继续计时
+	next := time.Now()
+	list, err := listSnapshots(dir)
+	if err != nil {
+		log.Println("error: backup:", err)
+	}
+	if len(list) > 0 {
+		next = list[0].at.Add(cfg.backupInterval)
+
+		backupMu.Lock()
+		backupStatus.Snapshots = len(list)
+		backupStatus.LastOkAt = &list[0].at
+		backupStatus.LastFile = list[0].name
+		backupMu.Unlock()
+	}
+
+	for {
+		backupMu.Lock()
+		backupStatus.NextAt = &next
+		backupMu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		name, size, err := takeSnapshot(ctx, dir, now)
+		if ctx.Err() != nil {
+			return
+		}
+
+		var n int
+		if err == nil {
+			list, err = pruneSnapshots(dir, cfg.backupDaily, cfg.backupWeekly)
+			n = len(list)
+		}
+
+		backupMu.Lock()
+		backupStatus.LastAt = &now
+		backupStatus.LastError = ""
+		if name != "" {
+			backupStatus.LastOkAt = &now
+			backupStatus.LastFile = name
+			backupStatus.LastSize = size
+		}
+		if err != nil {
+			backupStatus.LastError = err.Error()
+		} else {
+			backupStatus.Snapshots = n
+		}
+		backupMu.Unlock()
+
+		if err != nil {
+			log.Println("error: backup:", err)
+		} else {
+			log.Println("backup written to", name)
+		}
+
+		next = now.Add(cfg.backupInterval)
+		if name == "" {
+			next = now.Add(min(cfg.backupInterval, snapshotRetry))
+		}
+	}
+}
+
+// 写入快照并通过 integrity_check 后改为正式文件名
+func takeSnapshot(ctx context.Context, dir string, now time.Time) (string, int64, error) {
+	name := snapshotPrefix + now.Format(snapshotLayout) + ".db"
+	tmp := filepath.Join(dir, name+".tmp")
+	defer os.Remove(tmp)
+
+	os.Remove(tmp)
+	err := db.WithContext(ctx).Exec("VACUUM INTO ?", tmp).Error
+	if err != nil {
+		return "", 0, err
+	}
+
+	g, err := openDb(tmp)
+	if err != nil {
+		return "", 0, err
+	}
+	err = integrityCheck(g)
+	closeGormDb(g)
+	if err != nil {
+		return "", 0, err
+	}
+
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return "", 0, err
+	}
+	err = os.Rename(tmp, filepath.Join(dir, name))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return name, info.Size(), nil
+}
+
+// 列出快照，最新的在前
+func listSnapshots(dir string) ([]snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []snapshot
+	for _, e := range entries {
+		ts, ok := strings.CutPrefix(e.Name(), snapshotPrefix)
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		ts, ok = strings.CutSuffix(ts, ".db")
+		if !ok {
+			continue
+		}
+		at, err := time.ParseInLocation(snapshotLayout, ts, time.Local)
+		if err != nil {
+			continue
+		}
+		list = append(list, snapshot{name: e.Name(), at: at})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].at.After(list[j].at)
+	})
+
+	return list, nil
+}
+
+// 保留最近 daily 天与 weekly 周各自最新的一份，其余删除，返回保留的快照
+func pruneSnapshots(dir string, daily int, weekly int) ([]snapshot, error) {
+	list, err := listSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	var kept []snapshot
+	for i, s := range list {
+		keep := i == 0
+
+		day := s.at.Format("2006-01-02")
+		if !days[day] && len(days) < daily {
+			days[day] = true
+			keep = true
+		}
+
+		year, week := s.at.ISOWeek()
+		wk := fmt.Sprintf("%d-%02d", year, week)
+		if !weeks[wk] && len(weeks) < weekly {
+			weeks[wk] = true
+			keep = true
+		}
+
+		if keep {
+			kept = append(kept, s)
+			continue
+		}
+		err = os.Remove(filepath.Join(dir, s.name))
+		if err != nil {
+			return kept, err
+		}
+	}
+
+	return kept, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPruneSnapshots(t *testing.T) {
+	// 2024-01-08 至 01-14 为同一 ISO 周，day 为 0 时即 2023-12-31
+	at := func(day, hour int) string {
+		return time.Date(2024, 1, day, hour, 0, 0, 0, time.Local).Format(snapshotLayout)
+	}
+
+	tests := []struct {
+		name          string
+		files         []string
+		daily, weekly int
+		want          []string // 保留的快照，最新的在前
+	}{
+		{
+			"latest per day",
+			[]string{at(10, 1), at(10, 2), at(9, 1), at(9, 2), at(8, 1)},
+			2, 0,
+			[]string{at(10, 2), at(9, 2)},
+		},
+		{
+			"latest per week",
+			[]string{at(10, 1), at(8, 1), at(7, 1), at(1, 1), at(0, 1)},
+			0, 3,
+			[]string{at(10, 1), at(7, 1), at(0, 1)},
+		},
+		{
+			"daily and weekly",
+			[]string{at(10, 1), at(9, 1), at(3, 1), at(2, 1)},
+			1, 2,
+			[]string{at(10, 1), at(3, 1)},
+		},
+		{
+			"keep newest",
+			[]string{at(10, 1), at(9, 1)},
+			0, 0,
+			[]string{at(10, 1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, ts := range tt.files {
+				err := os.WriteFile(filepath.Join(dir, snapshotPrefix+ts+".db"), nil, 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// 不属于快照的文件不受影响
+			err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			kept, err := pruneSnapshots(dir, tt.daily, tt.weekly)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, s := range kept {
+				got = append(got, s.at.Format(snapshotLayout))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.want)+1 {
+				t.Errorf("%d files left, want %d", len(entries), len(tt.want)+1)
+			}
+		})
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 备份包格式版本，包内结构变化时增加
+const backupFormat = 1
+
+// backupManifest 备份包说明，位于包内 manifest.json
+type backupManifest struct {
+	Format    int       `json:"format"`
+	Schema    int       `json:"schema"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     int       `json:"files"`
+	Missing   []string  `json:"missing,omitempty"` // 数据库中有记录但文件已丢失的上传文件
+}
+
+// 与数据库一同备份的数据目录文件，totp.key 缺失时两步验证密钥无法解密
+var backupExtras = []string{"totp.key", "banned_words.txt", "robots.txt"}
+
+var (
+	errDataDirLocked = errors.New("data directory is in use, stop the server first")
+
+	backupFilePattern = regexp.MustCompile(`^files/([0-9a-f]{2})/([0-9a-f]{64})$`)
+	backupDirPattern  = regexp.MustCompile(`^files(/[0-9a-f]{2})?/?$`)
+)
+
+// 服务运行期间持有数据目录锁，防止恢复时替换正在使用的数据库
+var unlockDataDir func()
+
+func initializeLock(cfg *config) {
+	var err error
+	unlockDataDir, err = lockDataDir(cfg.rootfs)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+}
+
+// 打开数据库文件，不做迁移
+func openDb(path string) (*gorm.DB, error) {
+	return gorm.Open(sqlite.Open(path), &gorm.Config{
+		TranslateError: true,
+		Logger:         logger.Default.LogMode(logger.Silent),
+	})
+}
+
+func closeGormDb(g *gorm.DB) {
+	if c, err := g.DB(); err == nil {
+		c.Close()
+	}
+}
+
+// 在线备份，VACUUM INTO 生成一致的数据库快照，再连同上传文件打包
+func createBackup(rootfs string, out string) (*backupManifest, error) {
+	tmp, err := os.MkdirTemp(rootfs, "backup-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	snap := filepath.Join(tmp, "data.db")
+	src, err := openDb(filepath.Join(rootfs, "data.db") + "?_journal=WAL")
+	if err != nil {
+		return nil, err
+	}
+	err = src.Exec("VACUUM INTO ?", snap).Error
+	closeGormDb(src)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := openDb(snap)
+	if err != nil {
+		return nil, err
+	}
+	var schema int
+	var hashes []string
+	err = g.Raw("PRAGMA user_version").Scan(&schema).Error
+	if err == nil {
+		err = g.Model(&File{}).Order("hash").Pluck("hash", &hashes).Error
+	}
+	closeGormDb(g)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &backupManifest{
+		Format:    backupFormat,
+		Schema:    schema,
+		CreatedAt: time.Now(),
+	}
+
+	// 先写临时文件，完成后再改名，避免留下不完整的备份包
+	f, err := os.OpenFile(out+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(out + ".tmp")
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	var files []string
+	for _, h := range hashes {
+		p := filepath.Join(rootfs, "files", h[:2], h)
+		if _, err := os.Stat(p); err != nil {
+			m.Missing = append(m.Missing, h)
+			continue
+		}
+		files = append(files, h)
+	}
+	m.Files = len(files)
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    "manifest.json",
+		Mode:    0o644,
+		Size:    int64(len(b)),
+		ModTime: m.CreatedAt,
+	})
+	if err == nil {
+		_, err = tw.Write(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = addTarFile(tw, snap, "data.db")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range backupExtras {
+		err = addTarFile(tw, filepath.Join(rootfs, name), name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, h := range files {
+		err = addTarFile(tw, filepath.Join(rootfs, "files", h[:2], h), path.Join("files", h[:2], h))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tw.Close()
+	if err == nil {
+		err = gw.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return m, os.Rename(out+".tmp", out)
+}
+
+func addTarFile(tw *tar.Writer, src string, name string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// 恢复备份，校验通过后替换数据库、上传文件与附带文件，原数据保留为 .bak，替换中途失败时全部还原
+func restoreBackup(rootfs string, in string) (*backupManifest, error) {
+	unlock, err := lockDataDir(rootfs)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	f, err := os.Open(in)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tmp, err := os.MkdirTemp(rootfs, "restore-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	// 自动备份生成的 .db 快照只包含数据库
+	var m *backupManifest
+	if strings.HasSuffix(in, ".db") {
+		m, err = copySnapshot(f, tmp)
+	} else {
+		m, err = extractBackup(f, tmp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup: %w", err)
+	}
+
+	err = checkSnapshot(filepath.Join(tmp, "data.db"), m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup: %w", err)
+	}
+
+	// 先准备好全部替换内容，再逐项替换，任一步失败时撤销之前的替换
+	names := []string{"data.db"}
+	if m.Files >= 0 {
+		err = os.MkdirAll(filepath.Join(tmp, "files"), 0o755)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, "files")
+
+		// 备份中缺少的附带文件保留现有的
+		for _, name := range backupExtras {
+			_, err = os.Stat(filepath.Join(tmp, name))
+			if err == nil {
+				names = append(names, name)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+
+	err = checkpointDb(filepath.Join(rootfs, "data.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	var rs restoreSwaps
+	for _, name := range names {
+		err = rs.swap(filepath.Join(rootfs, name), filepath.Join(tmp, name))
+		if err != nil {
+			return nil, errors.Join(err, rs.rollback())
+		}
+	}
+
+	// 合并 WAL 后已无未写入的内容，旧的 -wal 与 -shm 不能留给新数据库
+	dst := filepath.Join(rootfs, "data.db")
+	for _, suffix := range []string{"-wal", "-shm"} {
+		err = os.Remove(dst + suffix)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, errors.Join(err, rs.rollback())
+		}
+	}
+
+	return m, nil
+}
+
+// 解包到 dir，只接受已知的文件名，上传文件校验 sha256
+func extractBackup(r io.Reader, dir string) (*backupManifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	extras := make(map[string]bool)
+	for _, name := range backupExtras {
+		extras[name] = true
+	}
+
+	var m *backupManifest
+	var files int
+	seen := make(map[string]bool)
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// 手工重新打包时可能带有目录条目
+		if hdr.Typeflag == tar.TypeDir && backupDirPattern.MatchString(hdr.Name) {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, errors.New("unexpected entry " + hdr.Name)
+		}
+		if seen[hdr.Name] {
+			return nil, errors.New("duplicate entry " + hdr.Name)
+		}
+		seen[hdr.Name] = true
+
+		switch match := backupFilePattern.FindStringSubmatch(hdr.Name); {
+		case hdr.Name == "manifest.json":
+			m = &backupManifest{}
+			err = json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(m)
+			if err != nil {
+				return nil, errors.New("manifest.json: " + err.Error())
+			}
+			if m.Format != backupFormat {
+				return nil, fmt.Errorf("unsupported backup format %d", m.Format)
+			}
+		case hdr.Name == "data.db" || extras[hdr.Name]:
+			err = extractFile(tr, filepath.Join(dir, hdr.Name), "")
+		case match != nil && match[2][:2] == match[1]:
+			if hdr.Size > maxFileSize {
+				return nil, errors.New(hdr.Name + ": file too large")
+			}
+			err = extractFile(tr, filepath.Join(dir, filepath.FromSlash(hdr.Name)), match[2])
+			files++
+		default:
+			return nil, errors.New("unexpected entry " + hdr.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if m == nil {
+		return nil, errors.New("missing manifest.json")
+	}
+	if !seen["data.db"] {
+		return nil, errors.New("missing data.db")
+	}
+	if files != m.Files {
+		return nil, fmt.Errorf("manifest lists %d files, found %d", m.Files, files)
+	}
+
+	return m, nil
+}
+
+// 复制数据库快照到 dir，Files 为 -1 表示不替换上传文件
+func copySnapshot(r io.Reader, dir string) (*backupManifest, error) {
+	p := filepath.Join(dir, "data.db")
+	err := extractFile(r, p, "")
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := openDb(p)
+	if err != nil {
+		return nil, err
+	}
+	defer closeGormDb(g)
+
+	m := &backupManifest{Format: backupFormat, Files: -1}
+	err = g.Raw("PRAGMA user_version").Scan(&m.Schema).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// 写入文件，hash 非空时校验内容
+func extractFile(r io.Reader, dst string, hash string) error {
+	err := os.MkdirAll(filepath.Dir(dst), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return err
+	}
+	if hash != "" && hex.EncodeToString(h.Sum(nil)) != hash {
+		return errors.New("checksum mismatch for file " + hash)
+	}
+
+	return f.Close()
+}
+
+// 检查快照完整性与结构版本，较旧的结构在服务启动时自动迁移
+func checkSnapshot(p string, m *backupManifest) error {
+	g, err := openDb(p)
+	if err != nil {
+		return err
+	}
+	defer closeGormDb(g)
+
+	err = integrityCheck(g)
+	if err != nil {
+		return err
+	}
+
+	var schema int
+	err = g.Raw("PRAGMA user_version").Scan(&schema).Error
+	if err != nil {
+		return err
+	}
+	if schema != m.Schema {
+		return fmt.Errorf("schema %d does not match manifest %d", schema, m.Schema)
+	}
+	if schema > schemaVersion {
+		return fmt.Errorf("schema %d is newer than supported %d, upgrade sealog first", schema, schemaVersion)
+	}
+
+	// 只要求最初版本就有的表，其余的表在服务启动时由迁移补齐
+	for _, model := range []interface{}{&Mode{}, &Topic{}, &Post{}} {
+		if !g.Migrator().HasTable(model) {
+			return fmt.Errorf("data.db missing table for %T", model)
+		}
+	}
+
+	return nil
+}
+
+func integrityCheck(g *gorm.DB) error {
+	var result string
+	err := g.Raw("PRAGMA integrity_check").Scan(&result).Error
+	if err != nil {
+		return err
+	}
+	if result != "ok" {
+		return errors.New("integrity check: " + result)
+	}
+
+	return nil
+}
+
+// 合并数据库的 WAL，之后只替换 .db 文件即可
+func checkpointDb(p string) error {
+	_, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	g, err := openDb(p + "?_journal=WAL")
+	if err != nil {
+		return err
+	}
+	defer closeGormDb(g)
+
+	return g.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error
+}
+
+// restoreSwaps 记录已完成的替换，用于出错时撤销
+type restoreSwaps struct {
+	done []restoreSwap
+}
+
+type restoreSwap struct {
+	dst    string
+	backup bool // dst 原本存在并已移到 dst.bak
+}
+
+// 用 src 替换文件或目录 dst，原内容保留为 dst.bak
+func (rs *restoreSwaps) swap(dst string, src string) error {
+	err := os.RemoveAll(dst + ".bak")
+	if err != nil {
+		return err
+	}
+
+	sw := restoreSwap{dst: dst, backup: true}
+	err = os.Rename(dst, dst+".bak")
+	if errors.Is(err, fs.ErrNotExist) {
+		sw.backup = false
+	} else if err != nil {
+		return err
+	}
+
+	err = os.Rename(src, dst)
+	if err != nil {
+		if sw.backup {
+			err = errors.Join(err, os.Rename(dst+".bak", dst))
+		}
+		return err
+	}
+
+	rs.done = append(rs.done, sw)
+	return nil
+}
+
+// 按相反顺序还原原内容
+func (rs *restoreSwaps) rollback() error {
+	var errs []error
+	for i := len(rs.done) - 1; i >= 0; i-- {
+		sw := rs.done[i]
+		err := os.RemoveAll(sw.dst)
+		if err == nil && sw.backup {
+			err = os.Rename(sw.dst+".bak", sw.dst)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rollback %s: %w", sw.dst, err))
+		}
+	}
+	rs.done = nil
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 新建数据目录，含一个版块、一个上传文件与 totp.key
+func newTestDataDir(t *testing.T, name string) string {
+	t.Helper()
+
+	rootfs := t.TempDir()
+	g, err := openDb(filepath.Join(rootfs, "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeGormDb(g)
+
+	err = g.AutoMigrate(&Mode{}, &Topic{}, &Post{}, &User{}, &File{})
+	if err == nil {
+		err = g.Exec("PRAGMA user_version = " + strconv.Itoa(schemaVersion)).Error
+	}
+	if err == nil {
+		err = g.Create(&Mode{Name: name}).Error
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte(name)
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	err = g.Create(&File{Hash: hash, Name: name, Mime: "text/plain", Size: int64(len(data))}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Join(rootfs, "files", hash[:2]), 0o755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(rootfs, "files", hash[:2], hash), data, 0o644)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(rootfs, "totp.key"), data, 0o600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rootfs
+}
+
+func modeNames(t *testing.T, rootfs string) []string {
+	t.Helper()
+
+	g, err := openDb(filepath.Join(rootfs, "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeGormDb(g)
+
+	var names []string
+	err = g.Model(&Mode{}).Order("id").Pluck("name", &names).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+// 备份后恢复到另一数据目录，数据库、上传文件与附带文件均被替换，原内容保留为 .bak
+func TestBackupRestore(t *testing.T) {
+	src := newTestDataDir(t, "source")
+	out := filepath.Join(t.TempDir(), "backup.tar.gz")
+	m, err := createBackup(src, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Files != 1 || m.Schema != schemaVersion {
+		t.Errorf("manifest %+v", m)
+	}
+
+	dst := newTestDataDir(t, "target")
+	_, err = restoreBackup(dst, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"totp.key", "totp.key", "source"},
+		{"previous totp.key", "totp.key.bak", "target"},
+		{"file", "files", "source"},
+		{"previous files", "files.bak", "target"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dst, tt.path)
+			if info, err := os.Stat(p); err == nil && info.IsDir() {
+				sum := sha256.Sum256([]byte(tt.want))
+				hash := hex.EncodeToString(sum[:])
+				p = filepath.Join(p, hash[:2], hash)
+			}
+			b, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("got %q, want %q", b, tt.want)
+			}
+		})
+	}
+
+	if got := modeNames(t, dst); len(got) != 1 || got[0] != "source" {
+		t.Errorf("modes %q, want [source]", got)
+	}
+}
+
+type tarEntry struct {
+	name string
+	body string
+	dir  bool
+}
+
+func writeTestTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.dir {
+			hdr.Typeflag, hdr.Size, hdr.Mode = tar.TypeDir, 0, 0o755
+		}
+		err := tw.WriteHeader(hdr)
+		if err == nil {
+			_, err = tw.Write([]byte(e.body))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// 只接受已知的文件名，上传文件须与文件名中的 sha256 一致
+func TestExtractBackup(t *testing.T) {
+	sum := sha256.Sum256([]byte("x"))
+	hash := hex.EncodeToString(sum[:])
+	file := "files/" + hash[:2] + "/" + hash
+	manifest := func(files int) tarEntry {
+		return tarEntry{name: "manifest.json", body: `{"format":1,"schema":1,"files":` + strconv.Itoa(files) + `}`}
+	}
+	db := tarEntry{name: "data.db", body: "db"}
+
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		wantErr string
+	}{
+		{"valid", []tarEntry{manifest(1), db, {name: "files/", dir: true}, {name: file, body: "x"}, {name: "totp.key", body: "k"}}, ""},
+		{"missing manifest", []tarEntry{db}, "missing manifest.json"},
+		{"missing data.db", []tarEntry{manifest(0)}, "missing data.db"},
+		{"bad format", []tarEntry{{name: "manifest.json", body: `{"format":9}`}, db}, "unsupported backup format 9"},
+		{"traversal", []tarEntry{manifest(0), db, {name: "../evil", body: "x"}}, "unexpected entry ../evil"},
+		{"unknown file", []tarEntry{manifest(0), db, {name: "config.toml", body: "x"}}, "unexpected entry config.toml"},
+		{"wrong prefix", []tarEntry{manifest(1), db, {name: "files/00/" + hash, body: "x"}}, "unexpected entry"},
+		{"checksum", []tarEntry{manifest(1), db, {name: file, body: "y"}}, "checksum mismatch"},
+		{"duplicate", []tarEntry{manifest(0), db, db}, "duplicate entry data.db"},
+		{"file count", []tarEntry{manifest(2), db, {name: file, body: "x"}}, "manifest lists 2 files, found 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := extractBackup(bytes.NewReader(writeTestTar(t, tt.entries)), t.TempDir())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("err %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 任一替换失败时撤销之前的全部替换
+func TestRestoreSwaps_rollback(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, body string) {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a", "old a")
+	write("a.new", "new a")
+	write("b.new", "new b") // b 原本不存在
+
+	var rs restoreSwaps
+	for _, name := range []string{"a", "b"} {
+		err := rs.swap(filepath.Join(dir, name), filepath.Join(dir, name+".new"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := rs.swap(filepath.Join(dir, "c"), filepath.Join(dir, "missing"))
+	if err == nil {
+		t.Fatal("swap from missing source succeeded")
+	}
+	err = rs.rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string // 为空表示不存在
+	}{
+		{"a", "old a"},
+		{"a.bak", ""},
+		{"b", ""},
+	}
+	for _, tt := range tests {
+		b, err := os.ReadFile(filepath.Join(dir, tt.name))
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s exists", tt.name)
+			}
+			continue
+		}
+		if string(b) != tt.want {
+			t.Errorf("%s: got %q, %v", tt.name, b, err)
+		}
+	}
+}
+
+// 数据库中有记录但已丢失的文件不打包，由调用方提示
+func TestCreateBackup_missing(t *testing.T) {
+	src := newTestDataDir(t, "source")
+	g, err := openDb(filepath.Join(src, "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := strings.Repeat("0", 64)
+	err = g.Create(&File{Hash: missing, Name: "lost", Mime: "text/plain"}).Error
+	closeGormDb(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := createBackup(src, filepath.Join(t.TempDir(), "backup.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Files != 1 || len(m.Missing) != 1 || m.Missing[0] != missing {
+		t.Errorf("files %d, missing %q", m.Files, m.Missing)
+	}
+}
+
+// 只要求最初版本就有的表，其余的表由启动时的迁移补齐
+func TestCheckSnapshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		models  []any
+		schema  int
+		m       backupManifest
+		wantErr string
+	}{
+		{"current", []any{&Mode{}, &Topic{}, &Post{}, &User{}}, schemaVersion, backupManifest{Schema: schemaVersion}, ""},
+		{"before users", []any{&Mode{}, &Topic{}, &Post{}}, 0, backupManifest{}, ""},
+		{"missing posts", []any{&Mode{}, &Topic{}}, 0, backupManifest{}, "missing table"},
+		{"schema mismatch", []any{&Mode{}, &Topic{}, &Post{}}, 0, backupManifest{Schema: 1}, "does not match manifest"},
+		{"newer schema", []any{&Mode{}, &Topic{}, &Post{}}, schemaVersion + 1, backupManifest{Schema: schemaVersion + 1}, "upgrade sealog first"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "data.db")
+			g, err := openDb(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = g.AutoMigrate(tt.models...)
+			if err == nil {
+				err = g.Exec("PRAGMA user_version = " + strconv.Itoa(tt.schema)).Error
+			}
+			closeGormDb(g)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkSnapshot(p, &tt.m)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("err %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 静态站点目录的标记文件，只有带此文件的目录才会在重新生成时被整体替换
+const buildMarker = ".sealog-build"
+
+// 楼层中引用的上传文件
+var fileRef = regexp.MustCompile(`/file/([0-9a-f]{64})`)
+
+// buildReport 生成结果
+type buildReport struct {
+	Topics  int
+	Modes   int
+	Tags    int
+	Pages   int
+	Files   int
+	Skipped []string // 未生成的页面及原因
+}
+
+// siteBuilder 以 uid == -1 的视角生成页面，url 与服务端一致，页面写入 <url>/index.html
+type siteBuilder struct {
+	dir   string
+	base  string
+	files map[string]bool
+	rep   buildReport
+}
+
+// 生成到 out 同级的临时目录，完成后替换 out，已删除或转为私密的内容不会残留
+func buildSite(out string, base string, now time.Time) (*buildReport, error) {
+	out = filepath.Clean(out)
+	err := checkBuildDir(out)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp := out + ".tmp"
+	err = os.RemoveAll(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	b := &siteBuilder{
+		dir:   tmp,
+		base:  strings.TrimRight(base, "/"),
+		files: make(map[string]bool),
+	}
+
+	err = b.build(now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b.rep, swapBuildDir(tmp, out)
+}
+
+// out 须不存在、为空或由之前的 build 生成
+func checkBuildDir(out string) error {
+	entries, err := os.ReadDir(out)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && len(entries) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(filepath.Join(out, buildMarker))
+	if err != nil {
+		return errors.New(out + " is not empty and was not created by sealog build")
+	}
+
+	return nil
+}
+
+func swapBuildDir(tmp string, out string) error {
+	old := out + ".old"
+	err := os.RemoveAll(old)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(out)
+	if err == nil {
+		err = os.Rename(out, old)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Rename(tmp, out)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(old)
+}
+
+func (b *siteBuilder) build(now time.Time) error {
+	var ids []int
+	err := b.listing("/", "topics", func(page *htmlPage, offset int) error {
+		var topics []Topic
+		err := queryTopics(&topics, -1, offset)
+		if err != nil {
+			return err
+		}
+
+		topics = paginate(page, topics, offset)
+		for _, t := range topics {
+			ids = append(ids, t.Id)
+		}
+		page.Data = topics
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// /av 与首页内容相同，翻页指向首页的分页
+	index, err := os.ReadFile(filepath.Join(b.dir, "index.html"))
+	if err != nil {
+		return err
+	}
+	err = b.write("av/index.html", index)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = b.topic(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = b.modes()
+	if err != nil {
+		return err
+	}
+
+	err = b.tags()
+	if err != nil {
+		return err
+	}
+
+	err = b.feeds(0)
+	if err != nil {
+		return err
+	}
+
+	err = b.sitemap()
+	if err != nil {
+		return err
+	}
+
+	err = b.copyFiles()
+	if err != nil {
+		return err
+	}
+
+	err = b.copyAssets()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = pages["error"].ExecuteTemplate(&buf, "layout", htmlPage{
+		Title: "not found",
+		Data:  "not found",
+	})
+	if err != nil {
+		return err
+	}
+	err = b.write("404.html", buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return b.write(buildMarker, []byte(now.UTC().Format(time.RFC3339)+"\n"))
+}
+
+func (b *siteBuilder) write(name string, data []byte) error {
+	p := filepath.Join(b.dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(p), 0o755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(p, data, 0o644)
+}
+
+func (b *siteBuilder) page(url string, name string, page htmlPage) error {
+	var buf bytes.Buffer
+	err := pages[name].ExecuteTemplate(&buf, "layout", page)
+	if err != nil {
+		return err
+	}
+
+	b.rep.Pages++
+	return b.write(path.Join(url, "index.html"), buf.Bytes())
+}
+
+// 逐页生成主题列表，prefix 为第一页的 url，以 / 结尾
+func (b *siteBuilder) listing(prefix string, name string, fetch func(page *htmlPage, offset int) error) error {
+	for offset := 0; ; offset += pageSize {
+		page := htmlPage{}
+		err := fetch(&page, offset)
+		if err != nil {
+			return err
+		}
+		if page.HasPrev {
+			page.PrevUrl = pageUrl(prefix, page.Prev)
+		}
+		if page.HasNext {
+			page.NextUrl = pageUrl(prefix, page.Next)
+		}
+
+		err = b.page(pageUrl(prefix, offset), name, page)
+		if err != nil || !page.HasNext {
+			return err
+		}
+	}
+}
+
+// 第 n 页位于 <prefix>page/n/
+func pageUrl(prefix string, offset int) string {
+	if offset == 0 {
+		return prefix
+	}
+
+	return prefix + "page/" + strconv.Itoa(offset/pageSize+1) + "/"
+}
+
+// /av/:aid
+func (b *siteBuilder) topic(id int) error {
+	var res resAid
+	err, _, _ := queryTopicAndPosts(&res, -1, id)
+	if err != nil {
+		return fmt.Errorf("topic %d: %w", id, err)
+	}
+
+	for _, p := range res.Posts {
+		for _, m := range fileRef.FindAllStringSubmatch(p.ContentHtml, -1) {
+			b.files[m[1]] = true
+		}
+	}
+
+	b.rep.Topics++
+	return b.page("/av/"+strconv.Itoa(id), "topic", htmlPage{
+		Title: res.Topic.Title,
+		Data:  res,
+	})
+}
+
+// /cv 与 /cv/:cid，以及各版块的 feed
+func (b *siteBuilder) modes() error {
+	var modes []Mode
+	err := queryModes(&modes, -1)
+	if err != nil {
+		return err
+	}
+
+	err = b.page("/cv", "modes", htmlPage{
+		Title: "版块",
+		Data:  modes,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, mode := range modes {
+		cid := mode.Id
+		err = b.listing("/cv/"+strconv.Itoa(cid)+"/", "mode", func(page *htmlPage, offset int) error {
+			var res resCid
+			err, _, _ := queryTopicsByMode(&res, -1, cid, offset)
+			if err != nil {
+				return err
+			}
+
+			page.Title = res.Mode.Name
+			res.Topics = paginate(page, res.Topics, offset)
+			page.Data = res
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("mode %d: %w", cid, err)
+		}
+
+		err = b.feeds(cid)
+		if err != nil {
+			return fmt.Errorf("mode %d: %w", cid, err)
+		}
+		b.rep.Modes++
+	}
+
+	return nil
+}
+
+// /tag 与 /tag/:name，名称无法作为目录的标签跳过
+func (b *siteBuilder) tags() error {
+	var tags []resTagCount
+	err := queryTags(&tags, -1)
+	if err != nil {
+		return err
+	}
+
+	err = b.page("/tag", "tags", htmlPage{
+		Title: "标签",
+		Data:  tags,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		name := tag.Name
+		if !tagDir(name) {
+			b.rep.Skipped = append(b.rep.Skipped, "tag "+strconv.Quote(name)+": not usable as a directory name")
+			continue
+		}
+
+		err = b.listing("/tag/"+name+"/", "tag", func(page *htmlPage, offset int) error {
+			var res resTag
+			err, _, _ := queryTopicsByTag(&res, -1, name, offset)
+			if err != nil {
+				return err
+			}
+
+			page.Title = "#" + res.Tag.Name
+			res.Topics = paginate(page, res.Topics, offset)
+			page.Data = res
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("tag %s: %w", name, err)
+		}
+		b.rep.Tags++
+	}
+
+	return nil
+}
+
+// 标签名作为 /tag/ 下的单级目录，含 / 的名称与服务端 /tag/:name 不对应
+func tagDir(name string) bool {
+	return filepath.IsLocal(name) && name != "." && !strings.ContainsAny(name, `/\?#`)
+}
+
+// cid 为 0 时为全站 feed.xml 与 atom.xml，否则为 /cv/:cid 下的 feed
+func (b *siteBuilder) feeds(cid int) error {
+	var entries []feedEntry
+	title, link, dir := site.Title, "/", ""
+
+	err, _, _ := queryFeed(&entries, &title, cid)
+	if err != nil {
+		return err
+	}
+	if cid != 0 {
+		link = "/cv/" + strconv.Itoa(cid)
+		dir = link
+	}
+
+	var buf bytes.Buffer
+	err = writeRss(&buf, b.base, title, link, entries)
+	if err != nil {
+		return err
+	}
+	err = b.write(path.Join(dir, "feed.xml"), buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	buf.Reset()
+	err = writeAtom(&buf, b.base, title, link, entries)
+	if err != nil {
+		return err
+	}
+
+	return b.write(path.Join(dir, "atom.xml"), buf.Bytes())
+}
+
+// sitemap 须使用完整地址，未指定站点地址时只输出 robots.txt
+func (b *siteBuilder) sitemap() error {
+	txt := robotsTxt
+	if b.base == "" {
+		return b.write("robots.txt", []byte(txt))
+	}
+	if !strings.Contains(strings.ToLower(txt), "sitemap:") {
+		txt = strings.TrimRight(txt, "\n") + "\nSitemap: " + b.base + "/sitemap.xml\n"
+	}
+	err := b.write("robots.txt", []byte(txt))
+	if err != nil {
+		return err
+	}
+
+	var urls []sitemapUrl
+	err = querySitemap(&urls)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if len(urls) <= sitemapSize {
+		err = writeSitemap(&buf, b.base, urls)
+		if err != nil {
+			return err
+		}
+		return b.write("sitemap.xml", buf.Bytes())
+	}
+
+	err = writeSitemapIndex(&buf, b.base, urls)
+	if err != nil {
+		return err
+	}
+	err = b.write("sitemap.xml", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(urls); i += sitemapSize {
+		buf.Reset()
+		err = writeSitemap(&buf, b.base, urls[i:min(i+sitemapSize, len(urls))])
+		if err != nil {
+			return err
+		}
+		err = b.write(fmt.Sprintf("sitemap/%d.xml", i/sitemapSize+1), buf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 只复制公开楼层引用的上传文件
+func (b *siteBuilder) copyFiles() error {
+	for hash := range b.files {
+		_, err := getFile(hash)
+		if err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filePath(hash))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = b.write("file/"+hash, data)
+		if err != nil {
+			return err
+		}
+		b.rep.Files++
+	}
+
+	return nil
+}
+
+// 前端构建产物，未嵌入时跳过
+func (b *siteBuilder) copyAssets() error {
+	assets, err := fs.Sub(web, "dist/assets")
+	if err != nil {
+		return err
+	}
+
+	return fs.WalkDir(assets, ".", func(name string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && name == "." {
+			return fs.SkipAll
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(assets, name)
+		if err != nil {
+			return err
+		}
+		return b.write(path.Join("assets", name), data)
+	})
+}
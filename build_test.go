@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTagDir(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"go", true},
+		{"中文", true},
+		{"a b", true},
+		{"a/b", false},
+		{`a\b`, false},
+		{"a?b", false},
+		{"a#b", false},
+		{".", false},
+		{"..", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tagDir(tt.name); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageUrl(t *testing.T) {
+	tests := []struct {
+		prefix string
+		offset int
+		want   string
+	}{
+		{"/", 0, "/"},
+		{"/", pageSize, "/page/2/"},
+		{"/cv/3/", 2 * pageSize, "/cv/3/page/3/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := pageUrl(tt.prefix, tt.offset); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckBuildDir(t *testing.T) {
+	dir := t.TempDir()
+	mkdir := func(name string, files ...string) string {
+		p := filepath.Join(dir, name)
+		err := os.MkdirAll(p, 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			err = os.WriteFile(filepath.Join(p, f), nil, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		out     string
+		wantErr bool
+	}{
+		{"missing", filepath.Join(dir, "missing"), false},
+		{"empty", mkdir("empty"), false},
+		{"previous build", mkdir("built", buildMarker, "index.html"), false},
+		{"foreign", mkdir("foreign", "index.html"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBuildDir(tt.out)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 名称不能作为单级目录的标签不生成页面，记入报告
+func TestBuildSite_tags(t *testing.T) {
+	err := db.AutoMigrate(&Tag{}, &TopicTag{}, &User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	initializeTemplate()
+
+	topic := newTestTopic(t, true)
+	_, err = setTopicTags(topic.Id, []string{"build-ok", "build/slash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "site")
+	rep, err := buildSite(out, "", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		exists bool
+	}{
+		{"tag/build-ok/index.html", true},
+		{"tag/build/slash/index.html", false},
+		{"tag/build", false},
+		{"av/" + strconv.Itoa(topic.Id) + "/index.html", true},
+		{buildMarker, true},
+	}
+	for _, tt := range tests {
+		_, err := os.Stat(filepath.Join(out, filepath.FromSlash(tt.path)))
+		if (err == nil) != tt.exists {
+			t.Errorf("%s: exists %v, want %v", tt.path, err == nil, tt.exists)
+		}
+	}
+
+	if !slices.Contains(rep.Skipped, `tag "build/slash": not usable as a directory name`) {
+		t.Errorf("skipped %q", rep.Skipped)
+	}
+}
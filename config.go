@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// settings 配置文件内容，优先级：默认值 < 配置文件 < SEALOG_* 环境变量 < 命令行参数
+type settings struct {
+	Listen      string         `toml:"listen"       yaml:"listen"` // host:port 或 unix:/path/to.sock
+	TlsCert     string         `toml:"tls_cert"     yaml:"tls_cert"`
+	TlsKey      string         `toml:"tls_key"      yaml:"tls_key"`
+	DataDir     string         `toml:"data_dir"     yaml:"data_dir"`
+	LogPath     string         `toml:"log_path"     yaml:"log_path"`
+	CorsOrigins []string       `toml:"cors_origins" yaml:"cors_origins"`
+	JwtLifetime string         `toml:"jwt_lifetime" yaml:"jwt_lifetime"`
+	PageSize    int            `toml:"page_size"    yaml:"page_size"`
+	Site        siteMeta       `toml:"site"         yaml:"site"`
+	Backup      backupSettings `toml:"backup"       yaml:"backup"`
+
+	// 只有来自这些地址（ip 或 cidr）的请求才读取 X-Forwarded-For，默认不信任任何代理
+	TrustedProxies []string `toml:"trusted_proxies" yaml:"trusted_proxies"`
+}
+
+// backupSettings 自动备份，interval 为 0 时关闭
+type backupSettings struct {
+	Interval string `toml:"interval" yaml:"interval"`
+	Daily    int    `toml:"daily"    yaml:"daily"`  // 保留最近几天各一份
+	Weekly   int    `toml:"weekly"   yaml:"weekly"` // 保留最近几周各一份
+}
+
+// siteMeta 站点信息，url 为空时由请求推断
+type siteMeta struct {
+	Title       string `toml:"title"       yaml:"title"       json:"title"`
+	Description string `toml:"description" yaml:"description" json:"description"`
+	Url         string `toml:"url"         yaml:"url"         json:"url"`
+}
+
+// 配置项错误，key 为配置文件中的键名
+type configError struct {
+	key string
+	msg string
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("config %s (%s): %s", e.key, envName(e.key), e.msg)
+}
+
+func envName(key string) string {
+	return "SEALOG_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// 读取配置文件与环境变量，path 为空时使用 SEALOG_CONFIG
+func initializeConfig(cfg *config, path string) {
+	err := loadSettings(cfg, path)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+}
+
+func loadSettings(cfg *config, path string) error {
+	s := settings{
+		Listen:      cfg.listen,
+		DataDir:     cfg.rootfs,
+		CorsOrigins: []string{"*"},
+		JwtLifetime: tokenLifetime.String(),
+		PageSize:    pageSize,
+		Site:        site,
+		Backup: backupSettings{
+			Interval: "24h",
+			Daily:    7,
+			Weekly:   4,
+		},
+	}
+
+	if path == "" {
+		path = os.Getenv("SEALOG_CONFIG")
+	}
+
+	err := s.loadFile(path)
+	if err != nil {
+		return err
+	}
+	err = s.loadEnv()
+	if err != nil {
+		return err
+	}
+
+	return s.apply(cfg)
+}
+
+func (s *settings) loadFile(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		dec := toml.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(s)
+
+		var strict *toml.StrictMissingError
+		if errors.As(err, &strict) {
+			return fmt.Errorf("%s: unknown key %s", path, strings.Join(strict.Errors[0].Key(), "."))
+		}
+		var decode *toml.DecodeError
+		if errors.As(err, &decode) {
+			row, col := decode.Position()
+			return fmt.Errorf("%s:%d:%d: %s\n%s", path, row, col, decode.Error(), decode.String())
+		}
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		err = dec.Decode(s)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		return errors.New(path + ": unsupported config format, use .toml or .yaml")
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (s *settings) loadEnv() error {
+	for key, dst := range map[string]*string{
+		"listen":           &s.Listen,
+		"tls_cert":         &s.TlsCert,
+		"tls_key":          &s.TlsKey,
+		"data_dir":         &s.DataDir,
+		"log_path":         &s.LogPath,
+		"jwt_lifetime":     &s.JwtLifetime,
+		"site.title":       &s.Site.Title,
+		"site.description": &s.Site.Description,
+		"site.url":         &s.Site.Url,
+		"backup.interval":  &s.Backup.Interval,
+	} {
+		if v, ok := os.LookupEnv(envName(key)); ok {
+			*dst = v
+		}
+	}
+
+	for key, dst := range map[string]*[]string{
+		"cors_origins":    &s.CorsOrigins,
+		"trusted_proxies": &s.TrustedProxies,
+	} {
+		if v, ok := os.LookupEnv(envName(key)); ok {
+			*dst = nil
+			for _, o := range strings.Split(v, ",") {
+				if o = strings.TrimSpace(o); o != "" {
+					*dst = append(*dst, o)
+				}
+			}
+		}
+	}
+
+	for key, dst := range map[string]*int{
+		"page_size":     &s.PageSize,
+		"backup.daily":  &s.Backup.Daily,
+		"backup.weekly": &s.Backup.Weekly,
+	} {
+		if v, ok := os.LookupEnv(envName(key)); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return &configError{key, "not an integer"}
+			}
+			*dst = n
+		}
+	}
+
+	return nil
+}
+
+// 校验并写入 cfg 与全局设置
+func (s *settings) apply(cfg *config) error {
+	listen, err := checkListen(s.Listen)
+	if err != nil {
+		return &configError{"listen", err.Error()}
+	}
+
+	if (s.TlsCert == "") != (s.TlsKey == "") {
+		return &configError{"tls_cert", "tls_cert and tls_key must be set together"}
+	}
+	if s.TlsCert != "" {
+		_, err = tls.LoadX509KeyPair(s.TlsCert, s.TlsKey)
+		if err != nil {
+			return &configError{"tls_cert", err.Error()}
+		}
+	}
+
+	if s.DataDir == "" {
+		return &configError{"data_dir", "must not be empty"}
+	}
+	dir, err := filepath.Abs(s.DataDir)
+	if err != nil {
+		return &configError{"data_dir", err.Error()}
+	}
+	err = ensureDir(dir)
+	if err != nil {
+		return &configError{"data_dir", err.Error()}
+	}
+
+	logPath := s.LogPath
+	if logPath == "" {
+		logPath = filepath.Join(dir, "log.log")
+	}
+
+	for _, o := range s.CorsOrigins {
+		if o != "*" && !strings.HasPrefix(o, "http://") && !strings.HasPrefix(o, "https://") {
+			return &configError{"cors_origins", "invalid origin " + strconv.Quote(o)}
+		}
+	}
+
+	for _, p := range s.TrustedProxies {
+		_, _, err := net.ParseCIDR(p)
+		if err != nil && net.ParseIP(p) == nil {
+			return &configError{"trusted_proxies", "invalid address " + strconv.Quote(p)}
+		}
+	}
+
+	lifetime, err := time.ParseDuration(s.JwtLifetime)
+	if err != nil {
+		return &configError{"jwt_lifetime", err.Error()}
+	}
+	if lifetime < time.Minute {
+		return &configError{"jwt_lifetime", "must be at least 1m"}
+	}
+
+	if s.PageSize < 1 || s.PageSize > 100 {
+		return &configError{"page_size", "must be between 1 and 100"}
+	}
+
+	if strings.TrimSpace(s.Site.Title) == "" {
+		return &configError{"site.title", "must not be empty"}
+	}
+	if s.Site.Url != "" && !strings.HasPrefix(s.Site.Url, "http://") && !strings.HasPrefix(s.Site.Url, "https://") {
+		return &configError{"site.url", "must start with http:// or https://"}
+	}
+	s.Site.Url = strings.TrimRight(s.Site.Url, "/")
+
+	// "0"、"0s" 与 "0h" 均表示关闭
+	interval, err := time.ParseDuration(s.Backup.Interval)
+	if err != nil {
+		return &configError{"backup.interval", err.Error()}
+	}
+	if interval != 0 && interval < time.Minute {
+		return &configError{"backup.interval", "must be 0 or at least 1m"}
+	}
+	if s.Backup.Daily < 0 {
+		return &configError{"backup.daily", "must not be negative"}
+	}
+	if s.Backup.Weekly < 0 {
+		return &configError{"backup.weekly", "must not be negative"}
+	}
+
+	cfg.listen = listen
+	cfg.tlsCert = s.TlsCert
+	cfg.tlsKey = s.TlsKey
+	cfg.rootfs = dir
+	cfg.logPath = logPath
+	cfg.origins = s.CorsOrigins
+	cfg.proxies = s.TrustedProxies
+	cfg.backupInterval = interval
+	cfg.backupDaily = s.Backup.Daily
+	cfg.backupWeekly = s.Backup.Weekly
+	tokenLifetime = lifetime
+	pageSize = s.PageSize
+	site = s.Site
+
+	return nil
+}
+
+// 校验监听地址，unix 套接字路径转为绝对路径
+func checkListen(addr string) (string, error) {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		if path == "" {
+			return "", errors.New("missing socket path")
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		return unixPrefix + abs, nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", errors.New("invalid port " + strconv.Quote(port))
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+func ensureDir(dir string) error {
+	info, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.MkdirAll(dir, 0o755)
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(dir + " not a directory")
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 默认值 < 配置文件 < 环境变量
+func TestSettings_merge(t *testing.T) {
+	savedLifetime, savedPageSize, savedSite := tokenLifetime, pageSize, site
+	t.Cleanup(func() {
+		tokenLifetime, pageSize, site = savedLifetime, savedPageSize, savedSite
+	})
+
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		file    string // 文件名与内容，为空时不读取配置文件
+		content string
+		env     map[string]string
+		wantErr bool
+		check   func(t *testing.T, cfg *config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg *config) {
+				if cfg.listen != ":8080" || pageSize != 20 || !reflect.DeepEqual(cfg.origins, []string{"*"}) {
+					t.Errorf("got listen %q, page_size %d, origins %v", cfg.listen, pageSize, cfg.origins)
+				}
+			},
+		},
+		{
+			name:    "toml",
+			file:    "a.toml",
+			content: "listen = \"127.0.0.1:9000\"\npage_size = 30\ntrusted_proxies = [\"10.0.0.0/8\"]\n[site]\ntitle = \"From file\"\n",
+			check: func(t *testing.T, cfg *config) {
+				if cfg.listen != "127.0.0.1:9000" || pageSize != 30 || site.Title != "From file" ||
+					!reflect.DeepEqual(cfg.proxies, []string{"10.0.0.0/8"}) {
+					t.Errorf("got listen %q, page_size %d, title %q, proxies %v", cfg.listen, pageSize, site.Title, cfg.proxies)
+				}
+			},
+		},
+		{
+			name:    "yaml",
+			file:    "a.yaml",
+			content: "page_size: 40\nsite:\n  url: https://example.com/\n",
+			check: func(t *testing.T, cfg *config) {
+				if pageSize != 40 || site.Url != "https://example.com" {
+					t.Errorf("got page_size %d, url %q", pageSize, site.Url)
+				}
+			},
+		},
+		{
+			name:    "env over file",
+			file:    "b.toml",
+			content: "page_size = 30\ncors_origins = [\"https://a.example\"]\n[site]\ntitle = \"From file\"\n",
+			env: map[string]string{
+				"SEALOG_PAGE_SIZE":       "50",
+				"SEALOG_SITE_TITLE":      "From env",
+				"SEALOG_CORS_ORIGINS":    "https://b.example, https://c.example,",
+				"SEALOG_TRUSTED_PROXIES": "127.0.0.1",
+			},
+			check: func(t *testing.T, cfg *config) {
+				if pageSize != 50 || site.Title != "From env" ||
+					!reflect.DeepEqual(cfg.origins, []string{"https://b.example", "https://c.example"}) ||
+					!reflect.DeepEqual(cfg.proxies, []string{"127.0.0.1"}) {
+					t.Errorf("got page_size %d, title %q, origins %v, proxies %v", pageSize, site.Title, cfg.origins, cfg.proxies)
+				}
+			},
+		},
+		{name: "unknown key", file: "c.toml", content: "pagesize = 1\n", wantErr: true},
+		{name: "unsupported format", file: "c.json", content: "{}", wantErr: true},
+		{name: "bad integer", env: map[string]string{"SEALOG_PAGE_SIZE": "many"}, wantErr: true},
+		{name: "page size range", env: map[string]string{"SEALOG_PAGE_SIZE": "0"}, wantErr: true},
+		{name: "bad proxy", env: map[string]string{"SEALOG_TRUSTED_PROXIES": "proxy.local"}, wantErr: true},
+		{name: "bad site url", file: "d.yaml", content: "site:\n  url: example.com\n", wantErr: true},
+		{
+			name: "backup off",
+			env:  map[string]string{"SEALOG_BACKUP_INTERVAL": "0s"},
+			check: func(t *testing.T, cfg *config) {
+				if cfg.backupInterval != 0 {
+					t.Errorf("got backup interval %v", cfg.backupInterval)
+				}
+			},
+		},
+		{
+			name: "backup off in hours",
+			env:  map[string]string{"SEALOG_BACKUP_INTERVAL": "0h"},
+			check: func(t *testing.T, cfg *config) {
+				if cfg.backupInterval != 0 {
+					t.Errorf("got backup interval %v", cfg.backupInterval)
+				}
+			},
+		},
+		{name: "backup too often", env: map[string]string{"SEALOG_BACKUP_INTERVAL": "30s"}, wantErr: true},
+		{name: "bad backup interval", env: map[string]string{"SEALOG_BACKUP_INTERVAL": "daily"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenLifetime, pageSize, site = savedLifetime, savedPageSize, savedSite
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			var path string
+			if tt.file != "" {
+				path = filepath.Join(dir, tt.file)
+				err := os.WriteFile(path, []byte(tt.content), 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			t.Setenv("SEALOG_CONFIG", "")
+			cfg := &config{listen: ":8080", rootfs: filepath.Join(dir, "data")}
+			err := loadSettings(cfg, path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && tt.check != nil {
+				tt.check(t, cfg)
+			}
+		})
+	}
+}
@@ -2,36 +2,81 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"gorm.io/gorm"
 )
 
+// 楼层状态，pending 的游客回复审核通过后才分配楼层
+const (
+	postPending  = "pending"
+	postApproved = "approved"
+)
+
 type (
 	// Mode 帖子版块
 	Mode struct {
-		Id   int    `gorm:"primaryKey"    json:"id"`
-		Name string `gorm:"not null"      json:"name"`
-		Pub  bool   `gorm:"default:false" json:"pub"`
+		Id        int            `gorm:"primaryKey"    json:"id"`
+		Name      string         `gorm:"not null"      json:"name"`
+		Pub       bool           `gorm:"default:false" json:"pub"`
+		Guest     bool           `gorm:"default:false" json:"guest"` // 允许游客回复
+		DeletedAt gorm.DeletedAt `gorm:"index"         json:"-"`
 	}
 
 	// Topic 帖子主题
 	Topic struct {
-		Id        int       `gorm:"primaryKey"      json:"id"`
-		CreatedAt time.Time `gorm:"autoCreateTime"  json:"created_at"`
-		Title     string    `gorm:"not null"        json:"title"`
-		ModeId    int       `gorm:"index;default:0" json:"mode_id"`
-		Floors    int       `gorm:"default:0"       json:"-"`
+		Id        int            `gorm:"primaryKey"                       json:"id"`
+		CreatedAt time.Time      `gorm:"autoCreateTime"                   json:"created_at"`
+		Title     string         `gorm:"not null"                         json:"title"`
+		ModeId    int            `gorm:"index;default:0"                  json:"mode_id"`
+		UserId    int            `gorm:"index;default:0"                  json:"user_id"`
+		Floors    int            `gorm:"default:0"                        json:"-"`
+		OrphanOf  int            `gorm:"index;default:0"                  json:"-"` // 所属版块被删除前的 mode_id
+		Status    string         `gorm:"index;not null;default:published" json:"status"`
+		PublishAt *time.Time     `gorm:"index"                            json:"publish_at,omitempty"`
+		DeletedAt gorm.DeletedAt `gorm:"index"                            json:"-"`
+		Tags      []string       `gorm:"-"                                json:"tags,omitempty"`
 	}
 
-	// Post 帖子楼层
+	// Post 帖子楼层，待审核的游客回复 floor 为 0
 	Post struct {
-		Id        int       `gorm:"primaryKey"     json:"-"`
-		TopicId   int       `gorm:"index;not null" json:"topic_id"`
-		Floor     int       `gorm:"index;not null" json:"floor"`
-		UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
-		Content   string    `gorm:"not null"       json:"content"`
+		Id          int            `gorm:"primaryKey"                      json:"-"`
+		TopicId     int            `gorm:"index;not null"                  json:"topic_id"`
+		Floor       int            `gorm:"index;not null"                  json:"floor"`
+		UserId      int            `gorm:"index;default:0"                 json:"user_id"`
+		Author      string         `gorm:"not null;default:''"             json:"author,omitempty"` // 游客署名
+		Ip          string         `gorm:"not null;default:''"             json:"-"`
+		Status      string         `gorm:"index;not null;default:approved" json:"status"`
+		UpdatedAt   time.Time      `gorm:"autoUpdateTime"                  json:"updated_at"`
+		Content     string         `gorm:"not null"                        json:"content"`
+		ContentHtml string         `gorm:"not null;default:''"             json:"content_html"`
+		DeletedAt   gorm.DeletedAt `gorm:"index"                           json:"-"`
+	}
+
+	// Tag 主题标签
+	Tag struct {
+		Id   int    `gorm:"primaryKey"           json:"id"`
+		Name string `gorm:"uniqueIndex;not null" json:"name"`
+	}
+
+	// TopicTag 主题与标签关联
+	TopicTag struct {
+		TopicId int `gorm:"primaryKey"`
+		TagId   int `gorm:"primaryKey;index"`
+	}
+
+	// PostRevision 楼层修订记录，保存每次修改前的内容
+	PostRevision struct {
+		Id        int       `gorm:"primaryKey"                  json:"id"`
+		TopicId   int       `gorm:"index:idx_revision;not null" json:"topic_id"`
+		Floor     int       `gorm:"index:idx_revision;not null" json:"floor"`
+		CreatedAt time.Time `gorm:"autoCreateTime"              json:"created_at"`
+		Content   string    `gorm:"not null"                    json:"content"`
 	}
 )
 
@@ -45,9 +90,35 @@ func (m *Mode) delete() error {
 	return db.Where("id = ?", m.Id).Delete(m).Error
 }
 
+// 软删除时主题移至 mode_id = 0 并记录原版块，彻底删除时清除记录
 func (m *Mode) BeforeDelete(tx *gorm.DB) error {
-	return tx.Model(&Topic{}).Session(&gorm.Session{SkipHooks: true}).
-		Where("mode_id = ?", m.Id).Update("mode_id", 0).Error
+	if tx.Statement.Unscoped {
+		return tx.Model(&Topic{}).Session(&gorm.Session{SkipHooks: true}).Unscoped().
+			Where("orphan_of = ?", m.Id).Update("orphan_of", 0).Error
+	}
+
+	return tx.Model(&Topic{}).Session(&gorm.Session{SkipHooks: true}).Unscoped().
+		Where("mode_id = ?", m.Id).Updates(map[string]interface{}{
+		"mode_id":   0,
+		"orphan_of": m.Id,
+	}).Error
+}
+
+// m.Id
+func (m *Mode) restore() error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(m).Unscoped().Where("id = ?", m.Id).UpdateColumn("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+
+		// 仍在 mode_id = 0 的主题移回原版块
+		return tx.Model(&Topic{}).Session(&gorm.Session{SkipHooks: true}).Unscoped().
+			Where("orphan_of = ?", m.Id).Where("mode_id = 0").Updates(map[string]interface{}{
+			"mode_id":   m.Id,
+			"orphan_of": 0,
+		}).Error
+	})
 }
 
 // m.Id
@@ -56,7 +127,7 @@ func (m *Mode) update(data interface{}) error {
 	return db.Model(m).Where("id = ?", m.Id).Omit("id").Updates(data).Error
 }
 
-// t.Title, t.ModeId
+// t.Title, t.ModeId, t.UserId
 func (t *Topic) create() error {
 	return db.Create(t).Error
 }
@@ -73,8 +144,28 @@ func (t *Topic) delete() error {
 	return db.Where("id = ?", t.Id).Delete(t).Error
 }
 
+// 软删除保留楼层，彻底删除时一并清除
 func (t *Topic) BeforeDelete(tx *gorm.DB) error {
-	return tx.Where("topic_id = ?", t.Id).Delete(&Post{}).Error
+	if !tx.Statement.Unscoped {
+		return nil
+	}
+
+	err := tx.Where("topic_id = ?", t.Id).Delete(&PostRevision{}).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Where("topic_id = ?", t.Id).Delete(&TopicTag{}).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Unscoped().Where("topic_id = ?", t.Id).Delete(&Post{}).Error
+}
+
+// t.Id
+func (t *Topic) restore() error {
+	return db.Model(t).Unscoped().Where("id = ?", t.Id).UpdateColumn("deleted_at", nil).Error
 }
 
 // t.Id
@@ -116,7 +207,8 @@ func (t *Topic) BeforeUpdate(tx *gorm.DB) error {
 	return mode.stat("id")
 }
 
-// p.TopicId, p.Content
+// p.TopicId, p.UserId, p.Content
+// p.Status, p.Author, p.Ip
 func (p *Post) create() error {
 	topic := Topic{
 		Id: p.TopicId,
@@ -126,13 +218,23 @@ func (p *Post) create() error {
 		return err
 	}
 
-	p.Floor = topic.Floors + 1
+	if p.Status == "" {
+		p.Status = postApproved
+	}
+	if p.Status == postApproved {
+		p.Floor = topic.Floors + 1
+	}
+	p.ContentHtml = renderMarkdown(p.Content)
 
 	return db.Set("topic_id", p.TopicId).
 		Create(p).Error
 }
 
 func (p *Post) AfterCreate(tx *gorm.DB) error {
+	if p.Status == postPending {
+		return nil
+	}
+
 	tid, ok := tx.Get("topic_id")
 	if !ok {
 		return errors.New("no topic_id")
@@ -147,11 +249,251 @@ func (p *Post) delete() error {
 	return db.Where("topic_id = ?", p.TopicId).Where("floor = ?", p.Floor).Delete(p).Error
 }
 
+// p.TopicId, p.Floor
+func (p *Post) restore() error {
+	return db.Model(p).Unscoped().Where("topic_id = ?", p.TopicId).Where("floor = ?", p.Floor).
+		UpdateColumn("deleted_at", nil).Error
+}
+
 // p.TopicId, p.Floor
 // p.Content
 func (p *Post) update(data interface{}) error {
-	return db.Model(p).Where("topic_id = ?", p.TopicId).Where("floor = ?", p.Floor).
-		Select("content").Updates(data).Error
+	val := reflect.Indirect(reflect.ValueOf(data))
+	if val.Kind() != reflect.Struct {
+		return errors.New("not struct")
+	}
+
+	content := val.FieldByName("Content")
+	if !content.IsValid() || content.Kind() != reflect.String {
+		return errors.New("no content")
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var old Post
+		err := tx.Where("topic_id = ?", p.TopicId).Where("floor = ?", p.Floor).
+			Select("content").Take(&old).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err == nil && old.Content != content.String() {
+			rev := PostRevision{
+				TopicId: p.TopicId,
+				Floor:   p.Floor,
+				Content: old.Content,
+			}
+			err = tx.Create(&rev).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		res := tx.Model(p).Where("topic_id = ?", p.TopicId).Where("floor = ?", p.Floor).
+			Updates(map[string]interface{}{
+				"content":      content.String(),
+				"content_html": renderMarkdown(content.String()),
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// 按提交顺序通过待审核的回复并分配楼层，返回通过的回复
+func approvePosts(ids []int) ([]Post, error) {
+	var posts []Post
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("id IN ?", ids).Where("status = ?", postPending).Order("id").Find(&posts).Error
+		if err != nil {
+			return err
+		}
+
+		for i, p := range posts {
+			res := tx.Model(&Topic{}).Session(&gorm.Session{SkipHooks: true}).
+				Where("id = ?", p.TopicId).Update("floors", gorm.Expr("floors + 1"))
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+
+			var topic Topic
+			err = tx.Model(&topic).Where("id = ?", p.TopicId).Select("floors").Take(&topic).Error
+			if err != nil {
+				return err
+			}
+
+			err = tx.Model(&posts[i]).Where("id = ?", p.Id).Updates(map[string]interface{}{
+				"floor":  topic.Floors,
+				"status": postApproved,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+// 拒绝待审核的回复，直接彻底删除
+func rejectPosts(ids []int) (int64, error) {
+	res := db.Unscoped().Where("id IN ?", ids).Where("status = ?", postPending).Delete(&Post{})
+	return res.RowsAffected, res.Error
+}
+
+// 以 names 替换主题的全部标签，返回去重后的标签名
+func setTopicTags(tid int, names []string) ([]string, error) {
+	tags := cleanTags(names)
+
+	return tags, db.Transaction(func(tx *gorm.DB) error {
+		return writeTopicTags(tx, tid, tags)
+	})
+}
+
+// 去除空白与重复的标签名
+func cleanTags(names []string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		tags = append(tags, name)
+	}
+
+	return tags
+}
+
+// 标签名最大长度，按字符计
+const maxTagLength = 32
+
+// 标签名用作 /tag/:name 的路径参数，只允许文字、数字、空格与 -_.+
+func checkTag(name string) error {
+	if utf8.RuneCountInString(name) > maxTagLength {
+		return fmt.Errorf("tag %q is longer than %d characters", name, maxTagLength)
+	}
+	if strings.Trim(name, ".") == "" {
+		return fmt.Errorf("invalid tag %q", name)
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r) && !strings.ContainsRune(" -_.+", r) {
+			return fmt.Errorf("tag %q contains %q", name, r)
+		}
+	}
+
+	return nil
+}
+
+// tags 须已经过 cleanTags
+func checkTags(tags []string) error {
+	for _, name := range tags {
+		err := checkTag(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tags 须已经过 cleanTags 与 checkTags
+func writeTopicTags(tx *gorm.DB, tid int, tags []string) error {
+	err := tx.Where("topic_id = ?", tid).Delete(&TopicTag{}).Error
+	if err != nil {
+		return err
+	}
+
+	for _, name := range tags {
+		tag := Tag{
+			Name: name,
+		}
+		err = tx.Where("name = ?", name).FirstOrCreate(&tag).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(&TopicTag{TopicId: tid, TagId: tag.Id}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func queryTopicTags(tids []int) (map[int][]string, error) {
+	var rows []struct {
+		TopicId int
+		Name    string
+	}
+	err := db.Model(&TopicTag{}).Select("topic_tags.topic_id", "tags.name").
+		Joins("JOIN tags ON tags.id = topic_tags.tag_id").
+		Where("topic_tags.topic_id IN ?", tids).Order("tags.name").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[int][]string, len(tids))
+	for _, r := range rows {
+		tags[r.TopicId] = append(tags[r.TopicId], r.Name)
+	}
+
+	return tags, nil
+}
+
+// 彻底删除 before 之前移入回收站的内容
+func purgeTrash(before time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var topics []Topic
+		err := tx.Unscoped().Where("deleted_at < ?", before).Select("id").Find(&topics).Error
+		if err != nil {
+			return err
+		}
+		for _, t := range topics {
+			err = tx.Unscoped().Where("id = ?", t.Id).Delete(&t).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Where("EXISTS (?)", tx.Unscoped().Model(&Post{}).Select("1").
+			Where("posts.topic_id = post_revisions.topic_id").Where("posts.floor = post_revisions.floor").
+			Where("posts.deleted_at < ?", before)).Delete(&PostRevision{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Where("deleted_at < ?", before).Delete(&Post{}).Error
+		if err != nil {
+			return err
+		}
+
+		var modes []Mode
+		err = tx.Unscoped().Where("deleted_at < ?", before).Select("id").Find(&modes).Error
+		if err != nil {
+			return err
+		}
+		for _, m := range modes {
+			err = tx.Unscoped().Where("id = ?", m.Id).Delete(&m).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
 
 // m.Id
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 软删除后不可见，恢复后回到原位
+func TestCore_restore(t *testing.T) {
+	topic := newTestTopic(t, true)
+	post := Post{TopicId: topic.Id, Content: "first"}
+	err := post.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		obj     core
+		visible func() error
+	}{
+		{"post", &Post{TopicId: post.TopicId, Floor: post.Floor}, func() error {
+			return db.Where("id = ?", post.Id).Take(&Post{}).Error
+		}},
+		{"topic", &Topic{Id: topic.Id}, func() error {
+			return (&Topic{Id: topic.Id}).stat("id")
+		}},
+		{"mode", &Mode{Id: topic.ModeId}, func() error {
+			return (&Mode{Id: topic.ModeId}).stat("id")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := coreDelete(tt.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.visible() == nil {
+				t.Error("visible after delete")
+			}
+
+			err = coreRestore(tt.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.visible(); err != nil {
+				t.Errorf("after restore: %v", err)
+			}
+		})
+	}
+}
+
+// 删除版块时主题移至 mode_id = 0，恢复时只移回仍未归属其他版块的主题
+func TestMode_deleteOrphans(t *testing.T) {
+	moved := newTestTopic(t, true)
+	stay := Topic{Title: "stay", ModeId: moved.ModeId}
+	err := stay.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestTopic(t, true)
+
+	mode := Mode{Id: moved.ModeId}
+	err = mode.delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 恢复前将其中一个主题移到其他版块
+	err = (&Topic{Id: moved.Id}).update(struct{ ModeId *int }{ModeId: &other.ModeId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mode.restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		id     int
+		mode   int
+		orphan int
+	}{
+		{"moved away", moved.Id, other.ModeId, mode.Id},
+		{"restored", stay.Id, mode.Id, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Topic
+			err := db.Where("id = ?", tt.id).Take(&got).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ModeId != tt.mode || got.OrphanOf != tt.orphan {
+				t.Errorf("mode_id %d orphan_of %d, want %d %d", got.ModeId, got.OrphanOf, tt.mode, tt.orphan)
+			}
+		})
+	}
+}
+
+// 楼层不存在或已在回收站中时不算修改成功
+func TestPost_updateMissing(t *testing.T) {
+	withTestDb(t, &Mode{}, &Topic{}, &Post{}, &PostRevision{})
+
+	topic := newTestTopic(t, true)
+	for _, content := range []string{"a", "b"} {
+		post := Post{TopicId: topic.Id, Content: content}
+		err := post.create()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := (&Post{TopicId: topic.Id, Floor: 2}).delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		floor int
+		err   error
+	}{
+		{"exists", 1, nil},
+		{"deleted", 2, gorm.ErrRecordNotFound},
+		{"missing", 3, gorm.ErrRecordNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Post{TopicId: topic.Id, Floor: tt.floor}).update(Post{Content: "c"})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// 只彻底删除 before 之前移入回收站的内容，主题连同楼层与修订一并清除
+func TestPurgeTrash(t *testing.T) {
+	withTestDb(t, &Mode{}, &Topic{}, &Post{}, &PostRevision{}, &TopicTag{})
+
+	old := newTestTopic(t, true)
+	post := Post{TopicId: old.Id, Content: "a"}
+	err := post.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = post.update(Post{Content: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent := newTestTopic(t, true)
+
+	before := time.Now()
+	err = db.Where("id = ?", old.Id).Delete(&Topic{}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Model(&Topic{}).Unscoped().Where("id = ?", old.Id).UpdateColumn("deleted_at", before.Add(-time.Hour)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Where("id = ?", recent.Id).Delete(&Topic{}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = purgeTrash(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		model any
+		where string
+		id    int
+		want  int64
+	}{
+		{"old topic", &Topic{}, "id = ?", old.Id, 0},
+		{"old posts", &Post{}, "topic_id = ?", old.Id, 0},
+		{"old revisions", &PostRevision{}, "topic_id = ?", old.Id, 0},
+		{"recent topic", &Topic{}, "id = ?", recent.Id, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n int64
+			db.Unscoped().Model(tt.model).Where(tt.where, tt.id).Count(&n)
+			if n != tt.want {
+				t.Errorf("%d rows, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanTags(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{"nil", nil, nil},
+		{"trim", []string{" go ", "web"}, []string{"go", "web"}},
+		{"empty", []string{"", "  "}, nil},
+		{"duplicate", []string{"go", " go", "Go"}, []string{"go", "Go"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanTags(tt.names); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 标签名须能作为 /tag/:name 的路径参数
+func TestCheckTag(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  string
+		ok   bool
+	}{
+		{"ascii", "go-1.24_rc+1", true},
+		{"space", "static site", true},
+		{"cjk", "笔记", true},
+		{"max length", strings.Repeat("标", maxTagLength), true},
+		{"too long", strings.Repeat("a", maxTagLength+1), false},
+		{"slash", "a/b", false},
+		{"backslash", `a\b`, false},
+		{"query", "a?b", false},
+		{"fragment", "c#", false},
+		{"percent", "100%", false},
+		{"control", "a\tb", false},
+		{"dot", ".", false},
+		{"dot dot", "..", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTag(tt.tag)
+			if (err == nil) != tt.ok {
+				t.Errorf("err %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+// 设置标签替换原有标签，查询结果按名称排序
+func TestSetTopicTags(t *testing.T) {
+	err := db.AutoMigrate(&Tag{}, &TopicTag{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic := newTestTopic(t, true)
+
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{"set", []string{"tag-b", "tag-a"}, []string{"tag-a", "tag-b"}},
+		{"replace", []string{"tag-c", "tag-a", "tag-c"}, []string{"tag-a", "tag-c"}},
+		{"clear", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := setTopicTags(topic.Id, tt.names)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tags, err := queryTopicTags([]int{topic.Id})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tags[topic.Id]; !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 游客只能按标签看到公开版块中的主题，仅含非公开主题的标签不存在
+func TestQueryTopicsByTag(t *testing.T) {
+	withTestDb(t, &Mode{}, &Topic{}, &Post{}, &Tag{}, &TopicTag{}, &User{})
+
+	pub := newTestTopic(t, true)
+	private := newTestTopic(t, false)
+	for _, topic := range []Topic{pub, private} {
+		_, err := setTopicTags(topic.Id, []string{"by-tag-shared"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := setTopicTags(private.Id, []string{"by-tag-shared", "by-tag-private"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editor := User{Username: t.Name(), Hash: "-", Role: roleEditor}
+	err = db.Create(&editor).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		uid  int
+		tag  string
+		code int
+		want []int
+	}{
+		{"guest shared", -1, "by-tag-shared", 200, []int{pub.Id}},
+		{"guest private", -1, "by-tag-private", 404, nil},
+		{"editor shared", editor.Id, "by-tag-shared", 200, []int{private.Id, pub.Id}},
+		{"editor private", editor.Id, "by-tag-private", 200, []int{private.Id}},
+		{"missing", editor.Id, "by-tag-missing", 404, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res resTag
+			_, code, _ := queryTopicsByTag(&res, tt.uid, tt.tag, 0)
+			if code != tt.code {
+				t.Fatalf("code %d, want %d", code, tt.code)
+			}
+			var got []int
+			for _, topic := range res.Topics {
+				got = append(got, topic.Id)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 按提交顺序分配楼层，拒绝的回复彻底删除
+func TestApprovePosts(t *testing.T) {
+	topic := newTestTopic(t, true)
+	first := Post{TopicId: topic.Id, Content: "first"}
+	err := first.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pending []int
+	for i := 0; i < 3; i++ {
+		p := Post{TopicId: topic.Id, Content: "guest", Status: postPending}
+		err = p.create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending = append(pending, p.Id)
+	}
+
+	tests := []struct {
+		name   string
+		ids    []int
+		reject bool
+		floors []int
+	}{
+		{"approve in order", []int{pending[2], pending[0]}, false, []int{2, 3}},
+		{"already approved", []int{pending[0]}, false, nil},
+		{"reject", []int{pending[1]}, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.reject {
+				n, err := rejectPosts(tt.ids)
+				if err != nil || n != int64(len(tt.ids)) {
+					t.Fatalf("rejected %d: %v", n, err)
+				}
+				var left int64
+				db.Unscoped().Model(&Post{}).Where("id IN ?", tt.ids).Count(&left)
+				if left != 0 {
+					t.Errorf("%d rejected posts left", left)
+				}
+				return
+			}
+
+			posts, err := approvePosts(tt.ids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var floors []int
+			for _, p := range posts {
+				floors = append(floors, p.Floor)
+			}
+			if !slices.Equal(floors, tt.floors) {
+				t.Errorf("floors %v, want %v", floors, tt.floors)
+			}
+		})
+	}
+
+	var got Topic
+	db.Where("id = ?", topic.Id).Take(&got)
+	if got.Floors != 3 {
+		t.Errorf("topic floors %d, want 3", got.Floors)
+	}
+}
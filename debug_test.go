@@ -17,7 +17,7 @@ func TestMain(m *testing.M) {
 	db.TranslateError = true
 	db.Logger = logger.Default.LogMode(logger.Info)
 
-	_ = db.AutoMigrate(&Mode{}, &Topic{}, &Post{})
+	_ = db.AutoMigrate(&Mode{}, &Topic{}, &Post{}, &PostRevision{})
 	m.Run()
 }
 
@@ -148,7 +148,10 @@ func TestTopic_update(t *testing.T) {
 func TestPost_update(t *testing.T) {
 	post := Post{
 		TopicId: 1,
-		Floor:   2,
+		Content: "update",
+	}
+	if err := post.create(); err != nil {
+		t.Fatal(err)
 	}
 
 	b := Post{
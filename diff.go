@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unified diff 上下文行数
+const diffContext = 3
+
+// 编辑距离超过此值时不再逐行比较，整段替换，限制大文本的内存与耗时
+const maxDiffEdits = 1000
+
+type diffOp struct {
+	kind byte
+	a, b int
+	text string
+}
+
+// 按行比较 a、b，输出 unified diff，内容相同时返回空串
+func unifiedDiff(a, b, fromName, toName string) string {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var sb strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// 向后合并间隔不超过 2 * diffContext 的改动
+		start := max(i-diffContext, 0)
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			} else if j-end > 2*diffContext {
+				break
+			}
+		}
+		end = min(end+diffContext+1, len(ops))
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		writeHunk(&sb, ops[start:end])
+		i = end
+	}
+
+	return sb.String()
+}
+
+func writeHunk(w *strings.Builder, ops []diffOp) {
+	var na, nb int
+	for _, op := range ops {
+		if op.kind != '+' {
+			na++
+		}
+		if op.kind != '-' {
+			nb++
+		}
+	}
+
+	a, b := ops[0].a, ops[0].b
+	if na > 0 {
+		a++
+	}
+	if nb > 0 {
+		b++
+	}
+
+	fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", a, na, b, nb)
+	for _, op := range ops {
+		w.WriteByte(op.kind)
+		w.WriteString(op.text)
+		w.WriteByte('\n')
+	}
+}
+
+// 空文本为 0 行
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// 先去掉相同的首尾行，其余部分使用 Myers 算法
+func diffLines(a, b []string) []diffOp {
+	var pre int
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	var suf int
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b)-pre-suf)
+	for i := 0; i < pre; i++ {
+		ops = append(ops, diffOp{' ', i, i, a[i]})
+	}
+
+	ops = append(ops, myersDiff(a[pre:len(a)-suf], b[pre:len(b)-suf], pre)...)
+
+	for k := 0; k < suf; k++ {
+		ops = append(ops, diffOp{' ', len(a) - suf + k, len(b) - suf + k, a[len(a)-suf+k]})
+	}
+
+	return ops
+}
+
+// trace[d] 记录第 d 步后各对角线 k ∈ [-d, d] 到达的 x，回溯得到编辑序列
+// 内存为 O(D²)，D 超过 maxDiffEdits 时退化为整段删除再插入
+func myersDiff(a, b []string, off int) []diffOp {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxDiffEdits)
+
+	v := make([]int, 2*limit+3)
+	center := limit + 1
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[center+k-1] < v[center+k+1]) {
+				x = v[center+k+1]
+			} else {
+				x = v[center+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[center+k] = x
+
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[center-d:center+d+1]...))
+				return myersTrace(a, b, off, trace)
+			}
+		}
+		trace = append(trace, append([]int(nil), v[center-d:center+d+1]...))
+	}
+
+	ops := make([]diffOp, 0, n+m)
+	for i := range a {
+		ops = append(ops, diffOp{'-', off + i, off, a[i]})
+	}
+	for j := range b {
+		ops = append(ops, diffOp{'+', off + n, off + j, b[j]})
+	}
+	return ops
+}
+
+func myersTrace(a, b []string, off int, trace [][]int) []diffOp {
+	x, y := len(a), len(b)
+	var ops []diffOp
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		down := k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1])
+
+		pk := k - 1
+		if down {
+			pk = k + 1
+		}
+		sx := prev[pk+d-1]
+		if !down {
+			sx++
+		}
+
+		for x > sx {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', off + x, off + y, a[x]})
+		}
+		if down {
+			y--
+			ops = append(ops, diffOp{'+', off + x, off + y, b[y]})
+		} else {
+			x--
+			ops = append(ops, diffOp{'-', off + x, off + y, a[x]})
+		}
+	}
+	for x > 0 {
+		x--
+		y--
+		ops = append(ops, diffOp{' ', off + x, off + y, a[x]})
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "a\nb", "a\nb", ""},
+		{"both empty", "", "", ""},
+		{"from empty", "", "x", "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+x\n"},
+		{"to empty", "x", "", "--- a\n+++ b\n@@ -1,1 +0,0 @@\n-x\n"},
+		{"replace", "a\nb\nc", "a\nB\nc", "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"append", "a", "a\nb", "--- a\n+++ b\n@@ -1,1 +1,2 @@\n a\n+b\n"},
+		{
+			"context",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9",
+			"1\n2\n3\n4\nx\n6\n7\n8\n9",
+			"--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+x\n 6\n 7\n 8\n",
+		},
+		{
+			"two hunks",
+			"a\n1\n2\n3\n4\n5\n6\n7\n8\nb",
+			"A\n1\n2\n3\n4\n5\n6\n7\n8\nB",
+			"--- a\n+++ b\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+B\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff(tt.a, tt.b, "a", "b")
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want string // 各行的操作符
+	}{
+		{"empty", nil, nil, ""},
+		{"insert", nil, []string{"a", "b"}, "++"},
+		{"delete", []string{"a", "b"}, nil, "--"},
+		{"same", []string{"a", "b"}, []string{"a", "b"}, "  "},
+		{"middle", []string{"a", "b", "c"}, []string{"a", "x", "c"}, " -+ "},
+		{"move", []string{"a", "b", "c"}, []string{"b", "c", "a"}, "-  +"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var kinds []byte
+			for _, op := range diffLines(tt.a, tt.b) {
+				kinds = append(kinds, op.kind)
+			}
+			if string(kinds) != tt.want {
+				t.Errorf("got %q, want %q", kinds, tt.want)
+			}
+		})
+	}
+}
+
+// 编辑序列须能还原两侧文本，且改动行数与最长公共子序列一致
+func TestDiffLines_minimal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	gen := func() []string {
+		s := make([]string, r.Intn(12))
+		for i := range s {
+			s[i] = string(rune('a' + r.Intn(3)))
+		}
+		return s
+	}
+
+	for i := 0; i < 1000; i++ {
+		a, b := gen(), gen()
+
+		var ra, rb []string
+		var edits int
+		for _, op := range diffLines(a, b) {
+			if op.kind != '+' {
+				if op.a >= len(a) || a[op.a] != op.text {
+					t.Fatalf("%v %v: bad index a %d", a, b, op.a)
+				}
+				ra = append(ra, op.text)
+			}
+			if op.kind != '-' {
+				if op.b >= len(b) || b[op.b] != op.text {
+					t.Fatalf("%v %v: bad index b %d", a, b, op.b)
+				}
+				rb = append(rb, op.text)
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+
+		if strings.Join(ra, "\n") != strings.Join(a, "\n") || strings.Join(rb, "\n") != strings.Join(b, "\n") {
+			t.Fatalf("%v %v: not reconstructed", a, b)
+		}
+		if want := len(a) + len(b) - 2*lcsLen(a, b); edits != want {
+			t.Fatalf("%v %v: %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
+
+// 超过 maxDiffEdits 时整段删除再插入
+func TestDiffLines_limit(t *testing.T) {
+	n := maxDiffEdits
+	a := make([]string, n)
+	b := make([]string, n)
+	for i := range a {
+		a[i] = "a" + string(rune(i))
+		b[i] = "b" + string(rune(i))
+	}
+
+	ops := diffLines(a, b)
+	if len(ops) != 2*n {
+		t.Fatalf("got %d ops, want %d", len(ops), 2*n)
+	}
+	for i, op := range ops {
+		want := byte('-')
+		if i >= n {
+			want = '+'
+		}
+		if op.kind != want {
+			t.Fatalf("op %d: got %c, want %c", i, op.kind, want)
+		}
+	}
+}
+
+func lcsLen(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else {
+				dp[i][j] = max(dp[i+1][j], dp[i][j+1])
+			}
+		}
+	}
+	return dp[0][0]
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 
@@ -15,6 +16,10 @@ import (
 
 var db *gorm.DB
 
+// 数据库结构版本，保存在 PRAGMA user_version，恢复备份时用于判断兼容性。
+// AutoMigrate 无法处理的结构变化需要增加该值
+const schemaVersion = 1
+
 func initializeDbDrive(cfg *config) {
 	var err error
 	db, err = gorm.Open(
@@ -32,11 +37,19 @@ func initializeDbDrive(cfg *config) {
 	}
 
 	err = db.AutoMigrate(
-		&Mode{}, &Topic{}, &Post{}, &Auth{}, &Hmac{},
+		&Mode{}, &Topic{}, &Post{}, &PostRevision{}, &Tag{}, &TopicTag{}, &User{}, &Session{}, &RecoveryCode{}, &ApiToken{}, &Auth{}, &Hmac{}, &File{}, &Spam{},
 	)
 	if err != nil {
 		log.Fatalln("error:", err)
 	}
+
+	err = db.Exec("PRAGMA user_version = " + strconv.Itoa(schemaVersion)).Error
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+
+	initializeSearchDrive()
+	initializeMarkdown()
 }
 
 func closeDb() {
@@ -55,7 +68,12 @@ func initializeLogDrive(cfg *config) {
 		return
 	}
 
-	file, err := os.OpenFile(filepath.Join(cfg.rootfs, "log.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	err := os.MkdirAll(filepath.Dir(cfg.logPath), 0o755)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+
+	file, err := os.OpenFile(cfg.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
 		log.Fatalln("error:", err)
 	}
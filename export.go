@@ -0,0 +1,368 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// 导出格式版本，目录结构或 front matter 变化时增加
+const exportFormat = 1
+
+// exportManifest 导出包说明，位于 manifest.json
+type exportManifest struct {
+	Format     int           `json:"format"`
+	Generator  string        `json:"generator"`
+	ExportedAt time.Time     `json:"exported_at"`
+	Site       siteMeta      `json:"site"`
+	Users      []exportUser  `json:"users"`
+	Modes      []Mode        `json:"modes"`
+	Topics     []exportTopic `json:"topics"`
+	Files      []File        `json:"files"` // 内容位于 files/<hash>
+}
+
+type exportUser struct {
+	Id       int    `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+type exportTopic struct {
+	Id     int    `json:"id"`
+	Title  string `json:"title"`
+	ModeId int    `json:"mode_id"`
+	Path   string `json:"path"` // 主题目录，内含 index.md 与各楼层
+	Posts  int    `json:"posts"`
+}
+
+// topicMeta topics/<id>/index.md 的 front matter，date 沿用 Hugo 与 Jekyll 的键名
+type topicMeta struct {
+	Id        int        `yaml:"id"`
+	Title     string     `yaml:"title"`
+	ModeId    int        `yaml:"mode_id"`
+	Mode      string     `yaml:"mode,omitempty"`
+	UserId    int        `yaml:"user_id"`
+	Status    string     `yaml:"status"`
+	Date      time.Time  `yaml:"date"`
+	PublishAt *time.Time `yaml:"publish_at,omitempty"`
+	Floors    int        `yaml:"floors"`
+	Tags      []string   `yaml:"tags,omitempty"`
+}
+
+// postMeta 楼层文件的 front matter
+type postMeta struct {
+	Id        int       `yaml:"id"`
+	TopicId   int       `yaml:"topic_id"`
+	Floor     int       `yaml:"floor"`
+	UserId    int       `yaml:"user_id"`
+	Author    string    `yaml:"author,omitempty"`
+	Status    string    `yaml:"status"`
+	UpdatedAt time.Time `yaml:"updated_at"`
+}
+
+// exportData 同一读事务中取出的全部内容，回收站中的内容不导出
+type exportData struct {
+	users  []User
+	modes  []Mode
+	topics []Topic
+	posts  map[int][]Post
+	files  []File
+}
+
+// exportSink 导出目标，目录或 tar 包
+type exportSink interface {
+	write(name string, b []byte, modTime time.Time) error
+	copy(name string, src string) error
+}
+
+type (
+	dirSink struct{ dir string }
+	tarSink struct{ tw *tar.Writer }
+)
+
+func (d dirSink) write(name string, b []byte, modTime time.Time) error {
+	p := filepath.Join(d.dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(p), 0o755)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(p, b, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(p, modTime, modTime)
+}
+
+func (d dirSink) copy(name string, src string) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	return d.write(name, b, info.ModTime())
+}
+
+func (t tarSink) write(name string, b []byte, modTime time.Time) error {
+	err := t.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(b)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = t.tw.Write(b)
+	return err
+}
+
+func (t tarSink) copy(name string, src string) error {
+	return addTarFile(t.tw, src, name)
+}
+
+func collectExport() (*exportData, error) {
+	d := &exportData{
+		posts: make(map[int][]Post),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Order("id").Find(&d.users).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Order("id").Find(&d.modes).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Order("id").Find(&d.topics).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Order("id").Find(&d.files).Error
+		if err != nil {
+			return err
+		}
+
+		var posts []Post
+		err = tx.Order("topic_id, floor, id").Find(&posts).Error
+		if err != nil {
+			return err
+		}
+		for _, p := range posts {
+			d.posts[p.TopicId] = append(d.posts[p.TopicId], p)
+		}
+
+		var rows []struct {
+			TopicId int
+			Name    string
+		}
+		err = tx.Model(&TopicTag{}).Select("topic_tags.topic_id", "tags.name").
+			Joins("JOIN tags ON tags.id = topic_tags.tag_id").Order("tags.name").Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+		tags := make(map[int][]string)
+		for _, r := range rows {
+			tags[r.TopicId] = append(tags[r.TopicId], r.Name)
+		}
+		for i := range d.topics {
+			d.topics[i].Tags = tags[d.topics[i].Id]
+		}
+
+		return nil
+	})
+
+	return d, err
+}
+
+// 依次写入 manifest.json、每个主题的 index.md 与楼层文件，以及上传文件
+func writeExport(sink exportSink, d *exportData, now time.Time) (*exportManifest, error) {
+	m := &exportManifest{
+		Format:     exportFormat,
+		Generator:  "sealog",
+		ExportedAt: now,
+		Site:       site,
+		Users:      []exportUser{},
+		Modes:      append([]Mode{}, d.modes...),
+		Topics:     []exportTopic{},
+		Files:      []File{},
+	}
+	for _, u := range d.users {
+		m.Users = append(m.Users, exportUser{Id: u.Id, Username: u.Username, Role: u.Role})
+	}
+	for _, t := range d.topics {
+		m.Topics = append(m.Topics, exportTopic{
+			Id:     t.Id,
+			Title:  t.Title,
+			ModeId: t.ModeId,
+			Path:   path.Join("topics", fmt.Sprint(t.Id)),
+			Posts:  len(d.posts[t.Id]),
+		})
+	}
+	for _, f := range d.files {
+		if _, err := os.Stat(filePath(f.Hash)); err == nil {
+			m.Files = append(m.Files, f)
+		}
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = sink.write("manifest.json", b, now)
+	if err != nil {
+		return nil, err
+	}
+
+	modeName := make(map[int]string)
+	for _, mode := range d.modes {
+		modeName[mode.Id] = mode.Name
+	}
+
+	for i, t := range d.topics {
+		dir := m.Topics[i].Path
+		b, err := frontMatter(topicMeta{
+			Id:        t.Id,
+			Title:     t.Title,
+			ModeId:    t.ModeId,
+			Mode:      modeName[t.ModeId],
+			UserId:    t.UserId,
+			Status:    t.Status,
+			Date:      t.CreatedAt,
+			PublishAt: t.PublishAt,
+			Floors:    t.Floors,
+			Tags:      t.Tags,
+		}, "")
+		if err != nil {
+			return nil, err
+		}
+		err = sink.write(path.Join(dir, "index.md"), b, t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range d.posts[t.Id] {
+			name := fmt.Sprintf("%04d.md", p.Floor)
+			if p.Status == postPending {
+				name = fmt.Sprintf("pending-%d.md", p.Id)
+			}
+
+			b, err := frontMatter(postMeta{
+				Id:        p.Id,
+				TopicId:   p.TopicId,
+				Floor:     p.Floor,
+				UserId:    p.UserId,
+				Author:    p.Author,
+				Status:    p.Status,
+				UpdatedAt: p.UpdatedAt,
+			}, p.Content)
+			if err != nil {
+				return nil, err
+			}
+			err = sink.write(path.Join(dir, name), b, p.UpdatedAt)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, f := range m.Files {
+		err = sink.copy(path.Join("files", f.Hash), filePath(f.Hash))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Markdown 文件，正文原样保留，不额外添加换行
+func frontMatter(meta interface{}, content string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err := enc.Encode(meta)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	buf.WriteString("---\n")
+	buf.WriteString(content)
+	return buf.Bytes(), nil
+}
+
+// 导出到 out，以 .tar.gz 或 .tgz 结尾时打包，否则写入空目录
+func exportTo(out string, now time.Time) (*exportManifest, error) {
+	d, err := collectExport()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(out, ".tar.gz") && !strings.HasSuffix(out, ".tgz") {
+		entries, err := os.ReadDir(out)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			return nil, errors.New(out + " is not empty")
+		}
+		return writeExport(dirSink{dir: out}, d, now)
+	}
+
+	f, err := os.OpenFile(out+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(out + ".tmp")
+	defer f.Close()
+
+	m, err := writeExportArchive(f, d, now)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return m, os.Rename(out+".tmp", out)
+}
+
+func writeExportArchive(w io.Writer, d *exportData, now time.Time) (*exportManifest, error) {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	m, err := writeExport(tarSink{tw: tw}, d, now)
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gw.Close()
+	}
+
+	return m, err
+}
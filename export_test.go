@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestExport(t *testing.T) *exportData {
+	t.Helper()
+
+	fileDir = t.TempDir()
+	hash := strings.Repeat("ab", 32)
+	err := os.MkdirAll(filepath.Dir(filePath(hash)), 0o755)
+	if err == nil {
+		err = os.WriteFile(filePath(hash), []byte("file"), 0o644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &exportData{
+		users: []User{{Id: 1, Username: "admin", Role: roleOwner}},
+		modes: []Mode{{Id: 1, Name: "notes"}},
+		topics: []Topic{
+			{Id: 7, Title: "hello", ModeId: 1, UserId: 1, Floors: 1, CreatedAt: at, Tags: []string{"go"}},
+		},
+		posts: map[int][]Post{7: {
+			{Id: 10, TopicId: 7, Floor: 1, UserId: 1, Content: "first\n", Status: postApproved, UpdatedAt: at},
+			{Id: 11, TopicId: 7, Author: "guest", Content: "wait", Status: postPending, UpdatedAt: at},
+		}},
+		files: []File{
+			{Hash: hash, Name: "a.txt"},
+			{Hash: strings.Repeat("cd", 32), Name: "missing.txt"}, // 文件已不在磁盘上
+		},
+	}
+}
+
+// 每个主题一个目录，已审核楼层按楼层号命名，待审核楼层按 id 命名
+func TestWriteExport(t *testing.T) {
+	d := newTestExport(t)
+	out := t.TempDir()
+	now := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	m, err := writeExport(dirSink{dir: out}, d, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 1 || m.Topics[0].Path != "topics/7" || m.Topics[0].Posts != 2 {
+		t.Errorf("manifest %+v", m)
+	}
+
+	tests := []struct {
+		name string
+		want []string // 文件中应包含的内容，nil 表示文件不存在
+	}{
+		{"manifest.json", []string{`"format": 1`, `"username": "admin"`, `"path": "topics/7"`}},
+		{"topics/7/index.md", []string{"---\nid: 7\n", "title: hello\n", "mode: notes\n", "date: 2024-01-02T03:04:05Z\n", "tags:\n  - go\n"}},
+		{"topics/7/0001.md", []string{"floor: 1\n", "status: approved\n", "---\nfirst\n"}},
+		{"topics/7/pending-11.md", []string{"author: guest\n", "status: pending\n", "---\nwait"}},
+		{"files/" + strings.Repeat("ab", 32), []string{"file"}},
+		{"files/" + strings.Repeat("cd", 32), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(tt.name)))
+			if tt.want == nil {
+				if err == nil {
+					t.Error("exists")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(string(b), s) {
+					t.Errorf("missing %q in\n%s", s, b)
+				}
+			}
+		})
+	}
+
+	info, err := os.Stat(filepath.Join(out, "topics", "7", "0001.md"))
+	if err != nil || !info.ModTime().Equal(d.posts[7][0].UpdatedAt) {
+		t.Errorf("mod time %v, %v", info, err)
+	}
+}
+
+// 打包与写入目录的内容一致
+func TestWriteExportArchive(t *testing.T) {
+	d := newTestExport(t)
+	var buf bytes.Buffer
+	_, err := writeExportArchive(&buf, d, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == "manifest.json" {
+			var m exportManifest
+			err = json.NewDecoder(tr).Decode(&m)
+			if err != nil || m.Format != exportFormat {
+				t.Errorf("manifest %+v, %v", m, err)
+			}
+		}
+		names = append(names, hdr.Name)
+	}
+
+	want := []string{"manifest.json", "topics/7/index.md", "topics/7/0001.md", "topics/7/pending-11.md", "files/" + strings.Repeat("ab", 32)}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("got %q, want %q", names, want)
+	}
+}
+
+func TestFrontMatter(t *testing.T) {
+	tests := []struct {
+		name    string
+		meta    interface{}
+		content string
+		want    string
+	}{
+		{"empty content", struct{ Id int }{1}, "", "---\nid: 1\n---\n"},
+		{"content kept", struct{ Id int }{2}, "a\n\nb", "---\nid: 2\n---\na\n\nb"},
+		{"dashes in content", struct{ Id int }{3}, "---\n", "---\nid: 3\n---\n---\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := frontMatter(tt.meta, tt.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("got %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
+
+// 目录须为空，避免与旧导出混在一起
+func TestExportTo_notEmpty(t *testing.T) {
+	withTestDb(t, &User{}, &Mode{}, &Topic{}, &Post{}, &File{}, &Tag{}, &TopicTag{})
+	out := t.TempDir()
+	err := os.WriteFile(filepath.Join(out, "old"), nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = exportTo(out, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "is not empty") {
+		t.Errorf("err %v", err)
+	}
+}
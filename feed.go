@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type feedEntry struct {
+	Topic Topic
+	Post  Post
+}
+
+type (
+	rss struct {
+		XMLName xml.Name   `xml:"rss"`
+		Version string     `xml:"version,attr"`
+		Channel rssChannel `xml:"channel"`
+	}
+
+	rssChannel struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		Description   string    `xml:"description"`
+		LastBuildDate string    `xml:"lastBuildDate"`
+		Items         []rssItem `xml:"item"`
+	}
+
+	rssItem struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Guid        string `xml:"guid"`
+		PubDate     string `xml:"pubDate"`
+		Description string `xml:"description"`
+	}
+)
+
+type (
+	atom struct {
+		XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+		Title   string      `xml:"title"`
+		Id      string      `xml:"id"`
+		Links   []atomLink  `xml:"link"`
+		Updated string      `xml:"updated"`
+		Author  atomAuthor  `xml:"author"`
+		Entries []atomEntry `xml:"entry"`
+	}
+
+	atomLink struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr,omitempty"`
+	}
+
+	atomAuthor struct {
+		Name string `xml:"name"`
+	}
+
+	atomEntry struct {
+		Title     string      `xml:"title"`
+		Id        string      `xml:"id"`
+		Link      atomLink    `xml:"link"`
+		Published string      `xml:"published"`
+		Updated   string      `xml:"updated"`
+		Content   atomContent `xml:"content"`
+	}
+
+	atomContent struct {
+		Type string `xml:"type,attr"`
+		Body string `xml:",chardata"`
+	}
+)
+
+func feed(f *gin.RouterGroup) {
+	f.GET("/feed.xml", func(c *gin.Context) {
+		renderFeed(c, 0, writeRss)
+	})
+	f.GET("/atom.xml", func(c *gin.Context) {
+		renderFeed(c, 0, writeAtom)
+	})
+	f.GET("/cv/:cid/feed.xml", func(c *gin.Context) {
+		renderModeFeed(c, writeRss)
+	})
+	f.GET("/cv/:cid/atom.xml", func(c *gin.Context) {
+		renderModeFeed(c, writeAtom)
+	})
+}
+
+type feedWriter func(w io.Writer, base, title, link string, entries []feedEntry) error
+
+func renderModeFeed(c *gin.Context, fn feedWriter) {
+	cid, err := strconv.Atoi(c.Param("cid"))
+	if err != nil || cid <= 0 {
+		c.Status(404)
+		return
+	}
+
+	renderFeed(c, cid, fn)
+}
+
+func renderFeed(c *gin.Context, cid int, fn feedWriter) {
+	var entries []feedEntry
+	title, link := site.Title, "/"
+
+	err, code, _ := queryFeed(&entries, &title, cid)
+	if err != nil {
+		log.Println("error:", err)
+		c.Status(code)
+		return
+	}
+	if cid != 0 {
+		link = "/cv/" + strconv.Itoa(cid)
+	}
+
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	cacheAbsolute(c, 600)
+
+	err = fn(c.Writer, baseUrl(c), title, link, entries)
+	if err != nil {
+		log.Println("error:", err)
+	}
+}
+
+// 仅输出公开版块内容，与 queryTopics 中 uid == -1 的规则一致
+// cid 为 0 时为全站
+func queryFeed(dest *[]feedEntry, title *string, cid int) (error, int, string) {
+	var topics []Topic
+	if cid == 0 {
+		err := queryTopics(&topics, -1, 0)
+		if err != nil {
+			return err, 500, "server error"
+		}
+	} else {
+		var res resCid
+		err, code, msg := queryTopicsByMode(&res, -1, cid, 0)
+		if err != nil {
+			return err, code, msg
+		}
+		topics = res.Topics
+		*title = site.Title + " - " + res.Mode.Name
+	}
+
+	if len(topics) > pageSize {
+		topics = topics[:pageSize]
+	}
+	if len(topics) == 0 {
+		return nil, 200, ""
+	}
+
+	ids := make([]int, len(topics))
+	for i, t := range topics {
+		ids[i] = t.Id
+	}
+
+	// 每个主题的首个楼层
+	var posts []Post
+	subQuery := db.Model(&Post{}).Select("MIN(id)").Where("topic_id IN ?", ids).Where("status = ?", postApproved).Group("topic_id")
+	err := db.Where("id IN (?)", subQuery).Find(&posts).Error
+	if err != nil {
+		return err, 500, "server error"
+	}
+
+	first := make(map[int]Post, len(posts))
+	for _, p := range posts {
+		first[p.TopicId] = p
+	}
+
+	for _, t := range topics {
+		p, ok := first[t.Id]
+		if !ok {
+			continue
+		}
+		*dest = append(*dest, feedEntry{Topic: t, Post: p})
+	}
+
+	return nil, 200, ""
+}
+
+func writeRss(w io.Writer, base, title, link string, entries []feedEntry) error {
+	doc := rss{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        base + link,
+			Description: title,
+		},
+	}
+
+	for _, e := range entries {
+		href := base + "/av/" + strconv.Itoa(e.Topic.Id)
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       e.Topic.Title,
+			Link:        href,
+			Guid:        href,
+			PubDate:     e.Topic.CreatedAt.Format(time.RFC1123Z),
+			Description: e.Post.ContentHtml,
+		})
+	}
+	doc.Channel.LastBuildDate = lastUpdated(entries).Format(time.RFC1123Z)
+
+	return writeXml(w, doc)
+}
+
+func writeAtom(w io.Writer, base, title, link string, entries []feedEntry) error {
+	doc := atom{
+		Title: title,
+		Id:    base + link,
+		Links: []atomLink{
+			{Href: base + link},
+		},
+		Updated: lastUpdated(entries).Format(time.RFC3339),
+		Author: atomAuthor{
+			Name: site.Title,
+		},
+	}
+
+	for _, e := range entries {
+		href := base + "/av/" + strconv.Itoa(e.Topic.Id)
+		doc.Entries = append(doc.Entries, atomEntry{
+			Title:     e.Topic.Title,
+			Id:        href,
+			Link:      atomLink{Href: href},
+			Published: e.Topic.CreatedAt.Format(time.RFC3339),
+			Updated:   e.Post.UpdatedAt.Format(time.RFC3339),
+			Content: atomContent{
+				Type: "html",
+				Body: e.Post.ContentHtml,
+			},
+		})
+	}
+
+	return writeXml(w, doc)
+}
+
+func lastUpdated(entries []feedEntry) time.Time {
+	var t time.Time
+	for _, e := range entries {
+		if e.Post.UpdatedAt.After(t) {
+			t = e.Post.UpdatedAt
+		}
+	}
+	if t.IsZero() {
+		return time.Now()
+	}
+
+	return t
+}
+
+func writeXml(w io.Writer, v any) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(v)
+}
+
+// 可信代理的地址，与 gin 的 SetTrustedProxies 一致
+var trustedNets []*net.IPNet
+
+func initializeTrustedNets(proxies []string) {
+	trustedNets = nil
+	for _, p := range proxies {
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			ip := net.ParseIP(p)
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			n = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		trustedNets = append(trustedNets, n)
+	}
+}
+
+func fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	for _, n := range trustedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 站点根地址，未配置 site.url 时由请求推断，X-Forwarded-Proto 只取自可信代理
+func baseUrl(c *gin.Context) string {
+	if site.Url != "" {
+		return site.Url
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); fromTrustedProxy(c) && (proto == "https" || proto == "http") {
+		scheme = proto
+	}
+
+	return scheme + "://" + c.Request.Host
+}
+
+// 含绝对地址的响应，地址由请求的 Host 推断时不允许公共缓存，避免伪造的 Host 污染缓存
+func cacheAbsolute(c *gin.Context, maxAge int) {
+	if site.Url == "" {
+		c.Header("Cache-Control", "no-store")
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/xml"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBaseUrl(t *testing.T) {
+	savedSite, savedNets := site, trustedNets
+	t.Cleanup(func() {
+		site, trustedNets = savedSite, savedNets
+	})
+	initializeTrustedNets([]string{"10.0.0.0/8"})
+
+	tests := []struct {
+		name   string
+		url    string // site.url
+		remote string
+		tls    bool
+		proto  string
+		want   string
+	}{
+		{"configured", "https://blog.example", "192.0.2.1:1", false, "http", "https://blog.example"},
+		{"plain", "", "192.0.2.1:1", false, "", "http://host.example"},
+		{"tls", "", "192.0.2.1:1", true, "", "https://host.example"},
+		{"trusted proxy", "", "10.1.2.3:1", false, "https", "https://host.example"},
+		{"untrusted proxy", "", "192.0.2.1:1", false, "https", "http://host.example"},
+		{"bad proto", "", "10.1.2.3:1", false, "ftp", "http://host.example"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			site.Url = tt.url
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "http://host.example/feed.xml", nil)
+			c.Request.RemoteAddr = tt.remote
+			if tt.tls {
+				c.Request.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				c.Request.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+
+			if got := baseUrl(c); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 只输出公开版块的主题，地址由请求推断时不允许缓存
+func TestFeed(t *testing.T) {
+	err := db.AutoMigrate(&Tag{}, &TopicTag{}, &User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedSite := site
+	t.Cleanup(func() {
+		site = savedSite
+	})
+
+	pub := newTestTopic(t, true)
+	err = (&Post{TopicId: pub.Id, Content: "**pub**"}).create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	private := newTestTopic(t, false)
+	err = (&Post{TopicId: private.Id, Content: "private"}).create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	feed(r.Group("/"))
+
+	pubLink := "/av/" + strconv.Itoa(pub.Id)
+	pubMode := "/cv/" + strconv.Itoa(pub.ModeId)
+	tests := []struct {
+		name  string
+		url   string // site.url
+		path  string
+		code  int
+		cache string
+		links []string // 须出现的条目链接
+	}{
+		{"rss", "", "/feed.xml", 200, "no-store", []string{"http://example.com" + pubLink}},
+		{"atom", "https://blog.example", "/atom.xml", 200, "public, max-age=600", []string{"https://blog.example" + pubLink}},
+		{"mode rss", "", pubMode + "/feed.xml", 200, "no-store", []string{"http://example.com" + pubLink}},
+		{"mode atom", "", pubMode + "/atom.xml", 200, "no-store", []string{"http://example.com" + pubLink}},
+		{"private mode", "", "/cv/" + strconv.Itoa(private.ModeId) + "/feed.xml", 404, "", nil},
+		{"bad mode", "", "/cv/abc/feed.xml", 404, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			site.Url = tt.url
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d", w.Code, tt.code)
+			}
+			if tt.code != 200 {
+				return
+			}
+			if got := w.Header().Get("Cache-Control"); got != tt.cache {
+				t.Errorf("Cache-Control %q, want %q", got, tt.cache)
+			}
+
+			body := w.Body.String()
+			err := xml.Unmarshal(w.Body.Bytes(), new(struct{}))
+			if err != nil {
+				t.Fatalf("invalid xml: %v", err)
+			}
+			for _, link := range tt.links {
+				if !strings.Contains(body, link) {
+					t.Errorf("missing %s", link)
+				}
+			}
+			if strings.Contains(body, "/av/"+strconv.Itoa(private.Id)+"<") {
+				t.Error("private topic in feed")
+			}
+			if !strings.Contains(body, "&lt;strong&gt;pub&lt;/strong&gt;") {
+				t.Error("content html not escaped in feed")
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 单个上传文件大小上限
+const maxFileSize = 32 << 20
+
+// 上传请求体在文件之外留给 multipart 头部的余量
+const maxFormOverhead = 1 << 20
+
+var errFileTooLarge = errors.New("file too large")
+
+// File 上传文件
+type File struct {
+	Id        int       `gorm:"primaryKey"           json:"-"`
+	Hash      string    `gorm:"uniqueIndex;not null" json:"hash"`
+	Name      string    `gorm:"not null"             json:"name"`
+	Mime      string    `gorm:"not null"             json:"mime"`
+	Size      int64     `gorm:"not null"             json:"size"`
+	CreatedAt time.Time `gorm:"autoCreateTime"       json:"created_at"`
+}
+
+var fileDir string
+
+func initializeFileDrive(cfg *config) {
+	fileDir = filepath.Join(cfg.rootfs, "files")
+
+	err := os.MkdirAll(fileDir, 0o755)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+}
+
+// 以 sha256 作为文件名，相同内容只保存一份
+func filePath(hash string) string {
+	return filepath.Join(fileDir, hash[:2], hash)
+}
+
+func saveFile(r io.Reader, name string) (File, error) {
+	tmp, err := os.CreateTemp(fileDir, "upload-*")
+	if err != nil {
+		return File{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, maxFileSize+1))
+	if err != nil {
+		return File{}, err
+	}
+	if size > maxFileSize {
+		return File{}, errFileTooLarge
+	}
+
+	f := File{
+		Hash: hex.EncodeToString(h.Sum(nil)),
+		Name: filepath.Base(name),
+		Size: size,
+	}
+
+	err = db.Where("hash = ?", f.Hash).Take(&f).Error
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return File{}, err
+	}
+
+	f.Mime, err = detectMime(tmp, f.Name)
+	if err != nil {
+		return File{}, err
+	}
+
+	dst := filePath(f.Hash)
+	err = os.MkdirAll(filepath.Dir(dst), 0o755)
+	if err != nil {
+		return File{}, err
+	}
+	if _, err = os.Stat(dst); errors.Is(err, fs.ErrNotExist) {
+		err = tmp.Close()
+		if err != nil {
+			return File{}, err
+		}
+		err = os.Rename(tmp.Name(), dst)
+		if err != nil {
+			return File{}, err
+		}
+	}
+
+	// 并发上传相同内容时另一请求已写入记录，直接返回该记录
+	err = db.Create(&f).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return getFile(f.Hash)
+	}
+	return f, err
+}
+
+func detectMime(r io.ReadSeeker, name string) (string, error) {
+	b := make([]byte, 512)
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.ReadFull(r, b)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	typ := http.DetectContentType(b[:n])
+	if strings.HasPrefix(typ, "text/plain") || typ == "application/octet-stream" {
+		if ext := mime.TypeByExtension(filepath.Ext(name)); ext != "" {
+			typ = ext
+		}
+	}
+
+	return typ, nil
+}
+
+func getFile(hash string) (File, error) {
+	var f File
+
+	err := db.Where("hash = ?", hash).Take(&f).Error
+	return f, err
+}
+
+// 可在浏览器内直接打开的类型，其余一律作为附件下载
+func inlineMime(typ string) bool {
+	switch {
+	case typ == "image/svg+xml":
+		return false
+	case strings.HasPrefix(typ, "image/"),
+		strings.HasPrefix(typ, "video/"),
+		strings.HasPrefix(typ, "audio/"),
+		strings.HasPrefix(typ, "text/plain"),
+		typ == "application/pdf":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestInlineMime(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want bool
+	}{
+		{"image/png", true},
+		{"image/svg+xml", false},
+		{"video/mp4", true},
+		{"text/plain; charset=utf-8", true},
+		{"text/html; charset=utf-8", false},
+		{"application/pdf", true},
+		{"application/zip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			if got := inlineMime(tt.typ); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveFile(t *testing.T) {
+	err := db.AutoMigrate(&File{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileDir = t.TempDir()
+
+	tests := []struct {
+		name     string
+		content  string
+		filename string
+		mime     string
+		err      error
+	}{
+		{"text", "hello " + t.Name(), "a.txt", "text/plain; charset=utf-8", nil},
+		{"markdown", "# " + t.Name(), "a.md", "text/markdown; charset=utf-8", nil},
+		{"png", "\x89PNG\r\n\x1a\n" + t.Name(), "x.bin", "image/png", nil},
+		{"too large", strings.Repeat("x", maxFileSize+1), "big", "", errFileTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := saveFile(strings.NewReader(tt.content), "dir/"+tt.filename)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if f.Name != tt.filename || f.Mime != tt.mime || f.Size != int64(len(tt.content)) {
+				t.Errorf("got %+v", f)
+			}
+
+			// 相同内容只保存一份
+			again, err := saveFile(strings.NewReader(tt.content), "other")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.Id != f.Id {
+				t.Errorf("duplicate row: %d and %d", f.Id, again.Id)
+			}
+		})
+	}
+}
+
+// 并发上传相同内容均返回同一条记录
+func TestSaveFile_concurrent(t *testing.T) {
+	err := db.AutoMigrate(&File{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileDir = t.TempDir()
+
+	const n = 8
+	var wg sync.WaitGroup
+	files := make([]File, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			files[i], errs[i] = saveFile(strings.NewReader(t.Name()), "same.txt")
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("upload %d: %v", i, errs[i])
+		}
+		if files[i].Id != files[0].Id || files[i].Id == 0 {
+			t.Errorf("upload %d: id %d, want %d", i, files[i].Id, files[0].Id)
+		}
+	}
+}
+
+// 请求体超出限制时不读取整个 multipart 表单
+func TestUploadFile_tooLarge(t *testing.T) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = part.Write(make([]byte, maxFileSize+maxFormOverhead))
+	_ = w.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest("POST", "/api/upload", &body)
+	c.Request.Header.Set("Content-Type", w.FormDataContentType())
+	uploadFile(c)
+
+	if rec.Code != 413 {
+		t.Errorf("status %d, want 413", rec.Code)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	ipFailFree     = 5                  // 单个 IP 免于锁定的失败次数
+	globalFailFree = 50                 // 全局免于锁定的失败次数
+	maxIpLock      = time.Hour          // 单个 IP 最长锁定时间
+	maxGlobalLock  = 15 * time.Minute   // 全局最长锁定时间
+	failForget     = 24 * time.Hour     // 超过该时间没有失败则清零
+	trustFor       = 7 * 24 * time.Hour // 登录成功的 IP 在此时间内不受全局锁定
+)
+
+// loginFailure 连续登录失败记录
+type loginFailure struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+// 失败次数超过 free 后锁定 2^(count-free) 秒，不超过 limit
+func (f *loginFailure) add(now time.Time, free int, limit time.Duration) {
+	if now.Sub(f.last) > failForget {
+		f.count = 0
+	}
+	f.count++
+	f.last = now
+
+	if f.count <= free {
+		return
+	}
+
+	lock := limit
+	if n := f.count - free; n < 32 {
+		lock = min(time.Duration(1<<n)*time.Second, limit)
+	}
+	f.until = now.Add(lock)
+}
+
+// loginGuard 按 IP 与全局统计登录失败，指数退避锁定
+type loginGuard struct {
+	mu      sync.Mutex
+	ips     map[string]*loginFailure
+	global  loginFailure
+	trusted map[string]time.Time // IP 最近一次登录成功的时间
+}
+
+var guard = newLoginGuard()
+
+func newLoginGuard() loginGuard {
+	return loginGuard{
+		ips:     make(map[string]*loginFailure),
+		trusted: make(map[string]time.Time),
+	}
+}
+
+// 返回 ip 仍需等待的时长
+func (g *loginGuard) wait(ip string, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f, ok := g.ips[ip]
+	if !ok {
+		return 0
+	}
+	return max(f.until.Sub(now), 0)
+}
+
+// 返回全局锁定剩余时长，最近从 ip 登录成功过的用户不受全局锁定，
+// 避免他人轮换 IP 失败登录把常用设备一并锁在外面
+func (g *loginGuard) globalWait(ip string, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if at, ok := g.trusted[ip]; ok && now.Sub(at) <= trustFor {
+		return 0
+	}
+	return max(g.global.until.Sub(now), 0)
+}
+
+func (g *loginGuard) fail(ip string, now time.Time) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.ips) > 10000 {
+		for k, f := range g.ips {
+			if now.Sub(f.last) > failForget {
+				delete(g.ips, k)
+			}
+		}
+	}
+
+	f, ok := g.ips[ip]
+	if !ok {
+		f = &loginFailure{}
+		g.ips[ip] = f
+	}
+	f.add(now, ipFailFree, maxIpLock)
+	g.global.add(now, globalFailFree, maxGlobalLock)
+
+	return f.count
+}
+
+// 只清除 ip 的失败记录，全局记录由 failForget 过期，
+// 以免任一用户在轮换 IP 的尝试之间登录就能解除全局锁定
+func (g *loginGuard) succeed(ip string, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.trusted) > 10000 {
+		for k, at := range g.trusted {
+			if now.Sub(at) > trustFor {
+				delete(g.trusted, k)
+			}
+		}
+	}
+
+	delete(g.ips, ip)
+	g.trusted[ip] = now
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoginGuard(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		fails int           // 同一 ip 在 start 连续失败的次数
+		at    time.Duration // 相对 start 检查的时间
+		want  time.Duration
+	}{
+		{"free", ipFailFree, 0, 0},
+		{"first lock", ipFailFree + 1, 0, 2 * time.Second},
+		{"second lock", ipFailFree + 2, 0, 4 * time.Second},
+		{"partly waited", ipFailFree + 2, time.Second, 3 * time.Second},
+		{"expired", ipFailFree + 2, 5 * time.Second, 0},
+		{"capped", ipFailFree + 20, 0, maxIpLock},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newLoginGuard()
+			for i := 0; i < tt.fails; i++ {
+				g.fail("1.2.3.4", start)
+			}
+
+			got := g.wait("1.2.3.4", start.Add(tt.at))
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if w := g.wait("5.6.7.8", start.Add(tt.at)); w != 0 {
+				t.Errorf("other ip waits %v", w)
+			}
+		})
+	}
+}
+
+func TestLoginGuard_reset(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := newLoginGuard()
+	for i := 0; i < ipFailFree; i++ {
+		g.fail("1.2.3.4", start)
+	}
+
+	// 长时间没有失败后重新计数
+	if n := g.fail("1.2.3.4", start.Add(failForget+time.Second)); n != 1 {
+		t.Errorf("after %v: count %d, want 1", failForget, n)
+	}
+
+	// 登录成功后清零
+	for i := 0; i < ipFailFree+1; i++ {
+		g.fail("1.2.3.4", start)
+	}
+	g.succeed("1.2.3.4", start)
+	if w := g.wait("1.2.3.4", start); w != 0 {
+		t.Errorf("after succeed: waits %v", w)
+	}
+}
+
+// 来自不同 ip 的失败累计到全局锁定，登录成功不清除全局记录，只让该 ip 不受全局锁定
+func TestLoginGuard_global(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := newLoginGuard()
+	g.succeed("192.0.2.1", start.Add(-trustFor))
+	g.succeed("192.0.2.2", start.Add(-time.Hour))
+	for i := 0; i <= globalFailFree; i++ {
+		g.fail("10.0.0."+strconv.Itoa(i), start)
+	}
+	g.succeed("10.0.0.1", start)
+
+	tests := []struct {
+		name string
+		ip   string
+		at   time.Duration // 相对 start 检查的时间
+		want time.Duration
+	}{
+		{"new ip", "198.51.100.1", 0, 2 * time.Second},
+		{"partly waited", "198.51.100.1", time.Second, time.Second},
+		{"expired", "198.51.100.1", 2 * time.Second, 0},
+		{"trusted", "192.0.2.2", 0, 0},
+		{"trust at limit", "192.0.2.1", 0, 0},
+		{"trust expired", "192.0.2.1", time.Second, time.Second},
+		{"succeeded during lock", "10.0.0.1", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := g.globalWait(tt.ip, start.Add(tt.at)); w != tt.want {
+				t.Errorf("waits %v, want %v", w, tt.want)
+			}
+		})
+	}
+}
+
+// 全局锁定期间不校验密码，只有最近登录成功过的 ip 可以登录
+func TestVerifyAuthKey_globalLock(t *testing.T) {
+	withTestDb(t, &User{}, &Session{}, &Hmac{})
+	initializeHmac()
+
+	hash, err := cryptoPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Username: "guard-test", Hash: hash, Role: roleReader}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		guard = newLoginGuard()
+	})
+
+	tests := []struct {
+		name     string
+		ip       string
+		password string
+		want     int
+	}{
+		{"wrong password", "198.51.100.1", "guess", 429},
+		{"valid login", "198.51.100.1", "secret", 429},
+		{"trusted wrong password", "192.0.2.1", "guess", 401},
+		{"trusted valid login", "192.0.2.1", "secret", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard = newLoginGuard()
+			now := time.Now()
+			guard.succeed("192.0.2.1", now)
+			for i := 0; i <= globalFailFree; i++ {
+				guard.fail("10.0.0."+strconv.Itoa(i), now)
+			}
+
+			body := `{"username":"guard-test","password":"` + tt.password + `"}`
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/api/login", strings.NewReader(body))
+			c.Request.RemoteAddr = tt.ip + ":1234"
+			c.Request.Header.Set("Content-Type", "application/json")
+			verifyAuthKey(c)
+
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == 429 && w.Header().Get("Retry-After") == "" {
+				t.Error("missing Retry-After")
+			}
+		})
+	}
+}
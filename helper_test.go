@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// 在独立的数据库中运行，结束后恢复共享的 db
+func withTestDb(t *testing.T, models ...any) {
+	t.Helper()
+
+	saved := db
+	var err error
+	db, err = gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "data.db")), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		closeDb()
+		db = saved
+	})
+
+	err = db.AutoMigrate(models...)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 新建版块与主题，不依赖其他测试留下的数据
+func newTestTopic(t *testing.T, pub bool) Topic {
+	t.Helper()
+
+	mode := Mode{Name: t.Name(), Pub: pub}
+	err := mode.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic := Topic{Title: t.Name(), ModeId: mode.Id}
+	err = topic.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return topic
+}
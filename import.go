@@ -0,0 +1,515 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// importer 将外部内容转换为 importSet，新增格式只需实现该接口并加入 importers
+type importer interface {
+	format() string
+	detect(src *importSource) bool
+	load(src *importSource, opt *importOptions) (*importSet, error)
+}
+
+// 自动识别时按顺序尝试
+var importers = []importer{
+	sealogImporter{},
+	wxrImporter{},
+	markdownImporter{},
+}
+
+var errImportDryRun = errors.New("dry run")
+
+type importOptions struct {
+	format   string // auto 或 importer.format()
+	username string // 作者不存在时使用的用户
+	mode     string // 没有分类时放入的版块
+	dryRun   bool
+}
+
+// importSource 导入来源，目录与 tar.gz 读入 tree，单个文件读入 data
+type importSource struct {
+	path string
+	tree map[string][]byte // 以 / 分隔的相对路径
+	data []byte
+}
+
+// importSet 各导入器的统一输出
+type importSet struct {
+	modes   []importMode
+	topics  []importTopic
+	files   []importFile
+	skipped []string // 未导入的条目及原因
+}
+
+type importMode struct {
+	id    int // 希望保留的 id，已被占用时重新分配
+	name  string
+	pub   bool
+	guest bool
+}
+
+type importTopic struct {
+	id        int // 希望保留的 id，已被占用时重新分配
+	source    string
+	mode      string // 版块名，为空时 mode_id 为 0
+	username  string // 为空或不存在时使用 importOptions.username
+	title     string
+	status    string
+	createdAt time.Time
+	publishAt *time.Time
+	floors    int // 原楼层计数，保留已删除楼层占用的序号
+	tags      []string
+	posts     []importPost
+}
+
+type importPost struct {
+	id        int // 希望保留的 id，已被占用时重新分配
+	floor     int // 为 0 时按顺序分配
+	username  string
+	author    string // 游客署名
+	status    string
+	updatedAt time.Time
+	content   string
+}
+
+type importFile struct {
+	file File
+	data []byte
+}
+
+// importReport 导入结果，dry run 时为将要创建的内容
+type importReport struct {
+	format      string
+	dryRun      bool
+	modes       []string
+	reusedModes []string
+	topics      int
+	posts       int
+	pending     int
+	files       int
+	skipped     []string
+}
+
+func (r *importReport) String() string {
+	var b strings.Builder
+	if r.dryRun {
+		b.WriteString("dry run, nothing was written\n")
+	}
+	fmt.Fprintf(&b, "format: %s\n", r.format)
+	fmt.Fprintf(&b, "modes:  %d new %q, %d existing %q\n", len(r.modes), r.modes, len(r.reusedModes), r.reusedModes)
+	fmt.Fprintf(&b, "topics: %d\n", r.topics)
+	fmt.Fprintf(&b, "posts:  %d (%d pending)\n", r.posts, r.pending)
+	fmt.Fprintf(&b, "files:  %d\n", r.files)
+	if len(r.skipped) > 0 {
+		fmt.Fprintf(&b, "skipped %d:\n", len(r.skipped))
+		for _, s := range r.skipped {
+			b.WriteString("  " + s + "\n")
+		}
+	}
+	return b.String()
+}
+
+// 在单个事务中导入，dry run 时回滚
+func runImport(p string, opt *importOptions) (*importReport, error) {
+	src, err := readImportSource(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var imp importer
+	for _, i := range importers {
+		if opt.format == i.format() || opt.format == "auto" && i.detect(src) {
+			imp = i
+			break
+		}
+	}
+	if imp == nil {
+		if opt.format == "auto" {
+			return nil, errors.New("unrecognized format, use -f")
+		}
+		return nil, errors.New("unknown format " + opt.format)
+	}
+
+	set, err := imp.load(src, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &importReport{
+		format:  imp.format(),
+		dryRun:  opt.dryRun,
+		skipped: set.skipped,
+	}
+	var files []importFile
+	err = db.Transaction(func(tx *gorm.DB) error {
+		list, err := applyImport(tx, set, opt, rep)
+		if err == nil && opt.dryRun {
+			return errImportDryRun
+		}
+		files = list
+		return err
+	})
+	if errors.Is(err, errImportDryRun) {
+		return rep, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 文件按内容寻址，事务提交后再写入
+	for _, f := range files {
+		dst := filePath(f.file.Hash)
+		err = os.MkdirAll(filepath.Dir(dst), 0o755)
+		if err != nil {
+			return rep, err
+		}
+		err = os.WriteFile(dst, f.data, 0o644)
+		if err != nil {
+			return rep, err
+		}
+	}
+
+	return rep, nil
+}
+
+func applyImport(tx *gorm.DB, set *importSet, opt *importOptions, rep *importReport) ([]importFile, error) {
+	var owner User
+	err := tx.Where("username = ?", opt.username).Select("id").Take(&owner).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("user not found: " + opt.username)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]int)
+	userId := func(name string) (int, error) {
+		if name == "" {
+			return owner.Id, nil
+		}
+		if id, ok := users[name]; ok {
+			return id, nil
+		}
+
+		var u User
+		err := tx.Where("username = ?", name).Select("id").Take(&u).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			u.Id = owner.Id
+		} else if err != nil {
+			return 0, err
+		}
+		users[name] = u.Id
+		return u.Id, nil
+	}
+
+	// 同名版块直接使用
+	modes := make(map[string]int)
+	for _, m := range set.modes {
+		if _, ok := modes[m.name]; ok {
+			continue
+		}
+
+		var mode Mode
+		err := tx.Where("name = ?", m.name).Select("id").Take(&mode).Error
+		if err == nil {
+			modes[m.name] = mode.Id
+			rep.reusedModes = append(rep.reusedModes, m.name)
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		mode = Mode{Name: m.name, Pub: m.pub, Guest: m.guest}
+		if m.id > 0 {
+			free, err := idFree(tx, &Mode{}, m.id)
+			if err != nil {
+				return nil, err
+			}
+			if free {
+				mode.Id = m.id
+			}
+		}
+		err = tx.Create(&mode).Error
+		if err != nil {
+			return nil, err
+		}
+		modes[m.name] = mode.Id
+		rep.modes = append(rep.modes, m.name)
+	}
+
+	now := time.Now()
+	for _, t := range set.topics {
+		dated := !t.createdAt.IsZero()
+		if !dated {
+			t.createdAt = now
+		}
+		if t.status == "" {
+			t.status = statusPublished
+		}
+		err := checkSchedule(t.status, &t.publishAt)
+		if err != nil {
+			rep.skipped = append(rep.skipped, t.source+": "+err.Error())
+			continue
+		}
+
+		// 重复导入时跳过标题与创建时间相同的主题，来源没有日期时只比较标题
+		var same []Topic
+		err = tx.Where("title = ?", t.title).Select("id", "created_at").Find(&same).Error
+		if err != nil {
+			return nil, err
+		}
+		if !dated && len(same) > 0 || hasCreatedAt(same, t.createdAt) {
+			rep.skipped = append(rep.skipped, t.source+": already imported")
+			continue
+		}
+
+		modeId, ok := modes[t.mode]
+		if !ok && t.mode != "" {
+			return nil, errors.New(t.source + ": unknown mode " + t.mode)
+		}
+		uid, err := userId(t.username)
+		if err != nil {
+			return nil, err
+		}
+
+		// 未指定楼层的回复接在已有楼层之后
+		floors := t.floors
+		for _, p := range t.posts {
+			floors = max(floors, p.floor)
+		}
+		posts := make([]Post, 0, len(t.posts))
+		for _, p := range t.posts {
+			post := Post{
+				Id:        p.id,
+				Floor:     p.floor,
+				Author:    p.author,
+				Status:    p.status,
+				UpdatedAt: p.updatedAt,
+				Content:   p.content,
+			}
+			if post.Status == "" {
+				post.Status = postApproved
+			}
+			if post.Status == postPending {
+				post.Floor = 0
+			} else if post.Floor == 0 {
+				floors++
+				post.Floor = floors
+			}
+			if post.UpdatedAt.IsZero() {
+				post.UpdatedAt = t.createdAt
+			}
+			if p.author == "" {
+				post.UserId, err = userId(p.username)
+				if err != nil {
+					return nil, err
+				}
+			}
+			posts = append(posts, post)
+		}
+
+		topic := Topic{
+			CreatedAt: t.createdAt,
+			Title:     t.title,
+			ModeId:    modeId,
+			UserId:    uid,
+			Floors:    floors,
+			Status:    t.status,
+			PublishAt: t.publishAt,
+		}
+		if t.id > 0 {
+			free, err := idFree(tx, &Topic{}, t.id)
+			if err != nil {
+				return nil, err
+			}
+			if free {
+				topic.Id = t.id
+			}
+		}
+
+		// 跳过 hooks，版块检查与楼层计数在这里完成
+		err = tx.Session(&gorm.Session{SkipHooks: true}).Create(&topic).Error
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range posts {
+			if posts[i].Id > 0 {
+				free, err := idFree(tx, &Post{}, posts[i].Id)
+				if err != nil {
+					return nil, err
+				}
+				if !free {
+					posts[i].Id = 0
+				}
+			}
+			posts[i].TopicId = topic.Id
+			posts[i].ContentHtml = renderMarkdown(posts[i].Content)
+			err = tx.Session(&gorm.Session{SkipHooks: true}).Create(&posts[i]).Error
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", t.source, err)
+			}
+			rep.posts++
+			if posts[i].Status == postPending {
+				rep.pending++
+			}
+		}
+
+		var tags []string
+		for _, name := range cleanTags(t.tags) {
+			if err := checkTag(name); err != nil {
+				rep.skipped = append(rep.skipped, t.source+": "+err.Error())
+				continue
+			}
+			tags = append(tags, name)
+		}
+		err = writeTopicTags(tx, topic.Id, tags)
+		if err != nil {
+			return nil, err
+		}
+		rep.topics++
+	}
+
+	var files []importFile
+	for _, f := range set.files {
+		sum := sha256.Sum256(f.data)
+		if hex.EncodeToString(sum[:]) != f.file.Hash {
+			rep.skipped = append(rep.skipped, "files/"+f.file.Hash+": checksum mismatch")
+			continue
+		}
+
+		var n int64
+		err := tx.Model(&File{}).Where("hash = ?", f.file.Hash).Count(&n).Error
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			continue
+		}
+
+		f.file.Id = 0
+		f.file.Size = int64(len(f.data))
+		err = tx.Create(&f.file).Error
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		rep.files++
+	}
+
+	return files, nil
+}
+
+// 包括回收站中的记录
+func idFree(tx *gorm.DB, model interface{}, id int) (bool, error) {
+	var n int64
+	err := tx.Unscoped().Model(model).Where("id = ?", id).Count(&n).Error
+	return n == 0, err
+}
+
+func hasCreatedAt(topics []Topic, t time.Time) bool {
+	for _, topic := range topics {
+		if topic.CreatedAt.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// 读取目录、tar.gz 或单个文件
+func readImportSource(p string) (*importSource, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &importSource{path: p}
+	switch {
+	case info.IsDir():
+		src.tree = make(map[string][]byte)
+		err = filepath.WalkDir(p, func(name string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(p, name)
+			if err != nil {
+				return err
+			}
+			src.tree[filepath.ToSlash(rel)], err = os.ReadFile(name)
+			return err
+		})
+	case strings.HasSuffix(p, ".tar.gz") || strings.HasSuffix(p, ".tgz"):
+		src.tree, err = readTarTree(p)
+	default:
+		src.data, err = os.ReadFile(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return src, nil
+}
+
+func readTarTree(p string) (map[string][]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	tree := make(map[string][]byte)
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return tree, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, tr)
+		if err != nil {
+			return nil, err
+		}
+		tree[path.Clean(strings.TrimPrefix(hdr.Name, "./"))] = buf.Bytes()
+	}
+}
+
+// 按路径排序，保证导入顺序稳定
+func sortedNames(tree map[string][]byte) []string {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
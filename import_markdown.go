@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	sealogImporter   struct{}
+	markdownImporter struct{}
+)
+
+// 拆分 front matter 与正文，--- 为 YAML，+++ 为 TOML（Hugo）
+func splitFrontMatter(b []byte) (string, []byte, string, bool) {
+	s := strings.TrimPrefix(string(b), "\ufeff")
+
+	var delim string
+	switch {
+	case strings.HasPrefix(s, "---\n"), strings.HasPrefix(s, "---\r\n"):
+		delim = "---"
+	case strings.HasPrefix(s, "+++\n"), strings.HasPrefix(s, "+++\r\n"):
+		delim = "+++"
+	default:
+		return "", nil, s, false
+	}
+
+	_, rest, _ := strings.Cut(s, "\n")
+	meta := rest
+	for off := 0; ; {
+		line, next, ok := strings.Cut(rest[off:], "\n")
+		if strings.TrimRight(line, "\r") == delim {
+			body := ""
+			if ok {
+				body = next
+			}
+			return delim, []byte(meta[:off]), body, true
+		}
+		if !ok {
+			return "", nil, s, false
+		}
+		off += len(line) + 1
+	}
+}
+
+func decodeFrontMatter(delim string, meta []byte, v interface{}) error {
+	if delim == "+++" {
+		return toml.Unmarshal(meta, v)
+	}
+	return yaml.Unmarshal(meta, v)
+}
+
+func (sealogImporter) format() string {
+	return "sealog"
+}
+
+func (sealogImporter) detect(src *importSource) bool {
+	var m struct {
+		Generator string `json:"generator"`
+	}
+	b, ok := src.tree["manifest.json"]
+	return ok && json.Unmarshal(b, &m) == nil && m.Generator == "sealog"
+}
+
+// 读取 sealog export 的输出，保留 id、楼层与时间
+func (sealogImporter) load(src *importSource, opt *importOptions) (*importSet, error) {
+	b, ok := src.tree["manifest.json"]
+	if !ok {
+		return nil, errors.New("missing manifest.json")
+	}
+	var m exportManifest
+	err := json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, errors.New("manifest.json: " + err.Error())
+	}
+	if m.Format != exportFormat {
+		return nil, fmt.Errorf("unsupported export format %d", m.Format)
+	}
+
+	set := &importSet{}
+	users := make(map[int]string)
+	for _, u := range m.Users {
+		users[u.Id] = u.Username
+	}
+	modes := make(map[int]string)
+	for _, mode := range m.Modes {
+		modes[mode.Id] = mode.Name
+		set.modes = append(set.modes, importMode{id: mode.Id, name: mode.Name, pub: mode.Pub, guest: mode.Guest})
+	}
+
+	for _, ref := range m.Topics {
+		index := path.Join(ref.Path, "index.md")
+		b, ok := src.tree[index]
+		if !ok {
+			return nil, errors.New("missing " + index)
+		}
+		delim, meta, _, ok := splitFrontMatter(b)
+		if !ok {
+			return nil, errors.New(index + ": missing front matter")
+		}
+		var tm topicMeta
+		err := decodeFrontMatter(delim, meta, &tm)
+		if err != nil {
+			return nil, errors.New(index + ": " + err.Error())
+		}
+
+		t := importTopic{
+			id:        tm.Id,
+			source:    ref.Path,
+			mode:      modes[tm.ModeId],
+			username:  users[tm.UserId],
+			title:     tm.Title,
+			status:    tm.Status,
+			createdAt: tm.Date,
+			publishAt: tm.PublishAt,
+			floors:    tm.Floors,
+			tags:      tm.Tags,
+		}
+
+		var posts []importPost
+		var ids []int
+		for _, name := range sortedNames(src.tree) {
+			if path.Dir(name) != ref.Path || path.Base(name) == "index.md" || path.Ext(name) != ".md" {
+				continue
+			}
+			delim, meta, body, ok := splitFrontMatter(src.tree[name])
+			if !ok {
+				return nil, errors.New(name + ": missing front matter")
+			}
+			var pm postMeta
+			err := decodeFrontMatter(delim, meta, &pm)
+			if err != nil {
+				return nil, errors.New(name + ": " + err.Error())
+			}
+
+			posts = append(posts, importPost{
+				id:        pm.Id,
+				floor:     pm.Floor,
+				username:  users[pm.UserId],
+				author:    pm.Author,
+				status:    pm.Status,
+				updatedAt: pm.UpdatedAt,
+				content:   body,
+			})
+			ids = append(ids, pm.Id)
+		}
+
+		// 按原 id 排列，待审核回复保持提交顺序
+		sort.Sort(postsById{posts, ids})
+		t.posts = posts
+
+		set.topics = append(set.topics, t)
+	}
+
+	for _, f := range m.Files {
+		data, ok := src.tree["files/"+f.Hash]
+		if !ok {
+			set.skipped = append(set.skipped, "files/"+f.Hash+": missing")
+			continue
+		}
+		set.files = append(set.files, importFile{file: f, data: data})
+	}
+
+	return set, nil
+}
+
+type postsById struct {
+	posts []importPost
+	ids   []int
+}
+
+func (p postsById) Len() int           { return len(p.ids) }
+func (p postsById) Less(i, j int) bool { return p.ids[i] < p.ids[j] }
+func (p postsById) Swap(i, j int) {
+	p.posts[i], p.posts[j] = p.posts[j], p.posts[i]
+	p.ids[i], p.ids[j] = p.ids[j], p.ids[i]
+}
+
+func (markdownImporter) format() string {
+	return "markdown"
+}
+
+func (markdownImporter) detect(src *importSource) bool {
+	if src.tree == nil {
+		return isMarkdown(src.path)
+	}
+	for name := range src.tree {
+		if isMarkdown(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMarkdown(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown", ".mdown":
+		return true
+	}
+	return false
+}
+
+// Jekyll 文件名中的日期，如 2020-01-02-hello.md
+var jekyllName = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(.+)$`)
+
+// 读取 Hugo 与 Jekyll 的 Markdown，categories 第一项作为版块，正文作为一楼
+func (markdownImporter) load(src *importSource, opt *importOptions) (*importSet, error) {
+	tree := src.tree
+	if tree == nil {
+		tree = map[string][]byte{path.Base(src.path): src.data}
+	}
+
+	set := &importSet{}
+	seen := make(map[string]bool)
+	now := time.Now()
+	for _, name := range sortedNames(tree) {
+		if !isMarkdown(name) {
+			continue
+		}
+
+		// Hugo 列表页与 Jekyll 的模板目录没有正文内容
+		base := path.Base(name)
+		if strings.HasPrefix(base, "_index.") || skipJekyllDir(name) {
+			set.skipped = append(set.skipped, name+": not a post")
+			continue
+		}
+
+		delim, meta, body, ok := splitFrontMatter(tree[name])
+		if !ok {
+			set.skipped = append(set.skipped, name+": no front matter")
+			continue
+		}
+		fm := make(map[string]interface{})
+		err := decodeFrontMatter(delim, meta, &fm)
+		if err != nil {
+			return nil, errors.New(name + ": " + err.Error())
+		}
+
+		stem := strings.TrimSuffix(base, path.Ext(base))
+		if stem == "index" {
+			stem = path.Base(path.Dir(name))
+		}
+		var date time.Time
+		if m := jekyllName.FindStringSubmatch(stem); m != nil {
+			date, _ = time.ParseInLocation("2006-01-02", m[1], time.Local)
+			stem = m[2]
+		}
+		if d, ok := frontMatterTime(fm["date"]); ok {
+			date = d
+		}
+
+		t := importTopic{
+			source:    name,
+			mode:      opt.mode,
+			title:     strings.TrimSpace(fmt.Sprint(fm["title"])),
+			status:    statusPublished,
+			createdAt: date,
+			tags:      frontMatterList(fm["tags"]),
+			posts:     []importPost{{content: strings.TrimLeft(body, "\r\n")}},
+		}
+		if fm["title"] == nil || t.title == "" {
+			t.title = stem
+		}
+		if c := frontMatterList(fm["categories"]); len(c) > 0 {
+			t.mode = c[0]
+		}
+
+		// 草稿与未来日期的文章分别对应 draft 与 scheduled
+		draft, _ := fm["draft"].(bool)
+		published, ok := fm["published"].(bool)
+		if draft || ok && !published || strings.HasPrefix(name, "_drafts/") || strings.Contains(name, "/_drafts/") {
+			t.status = statusDraft
+		} else if date.After(now) {
+			t.status = statusScheduled
+			t.publishAt = &date
+		}
+
+		if !seen[t.mode] {
+			seen[t.mode] = true
+			set.modes = append(set.modes, importMode{name: t.mode, pub: true})
+		}
+		set.topics = append(set.topics, t)
+	}
+
+	return set, nil
+}
+
+// Jekyll 以 _ 开头的目录除 _posts 与 _drafts 外均不是文章
+func skipJekyllDir(name string) bool {
+	for _, dir := range strings.Split(path.Dir(name), "/") {
+		if strings.HasPrefix(dir, "_") && dir != "_posts" && dir != "_drafts" {
+			return true
+		}
+	}
+	return false
+}
+
+func frontMatterTime(v interface{}) (time.Time, bool) {
+	switch d := v.(type) {
+	case time.Time:
+		return d, true
+	case toml.LocalDateTime:
+		return d.AsTime(time.Local), true
+	case toml.LocalDate:
+		return d.AsTime(time.Local), true
+	case string:
+		for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05 -0700", "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+			t, err := time.ParseInLocation(layout, strings.TrimSpace(d), time.Local)
+			if err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// 列表或以空白分隔的字符串（Jekyll）
+func frontMatterList(v interface{}) []string {
+	switch l := v.(type) {
+	case string:
+		return strings.Fields(l)
+	case []interface{}:
+		var list []string
+		for _, item := range l {
+			if s := strings.TrimSpace(fmt.Sprint(item)); s != "" {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		delim string
+		meta  string
+		body  string
+		ok    bool
+	}{
+		{"yaml", "---\ntitle: a\n---\nbody\n", "---", "title: a\n", "body\n", true},
+		{"toml", "+++\ntitle = 'a'\n+++\nbody", "+++", "title = 'a'\n", "body", true},
+		{"crlf", "---\r\ntitle: a\r\n---\r\nbody", "---", "title: a\r\n", "body", true},
+		{"bom", "\ufeff---\ntitle: a\n---\nbody", "---", "title: a\n", "body", true},
+		{"empty meta", "---\n---\nbody", "---", "", "body", true},
+		{"no body", "---\ntitle: a\n---", "---", "title: a\n", "", true},
+		{"none", "body\n---\n", "", "", "body\n---\n", false},
+		{"unterminated", "---\ntitle: a\nbody", "", "", "---\ntitle: a\nbody", false},
+		{"rule only", "----\nbody", "", "", "----\nbody", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delim, meta, body, ok := splitFrontMatter([]byte(tt.src))
+			if delim != tt.delim || string(meta) != tt.meta || body != tt.body || ok != tt.ok {
+				t.Errorf("got (%q, %q, %q, %v), want (%q, %q, %q, %v)",
+					delim, meta, body, ok, tt.delim, tt.meta, tt.body, tt.ok)
+			}
+		})
+	}
+}
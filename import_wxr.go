@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	htmlatom "golang.org/x/net/html/atom"
+)
+
+type wxrImporter struct{}
+
+// WordPress 导出文件的结构，WXR 1.0 到 1.2 的命名空间不同，只按元素名匹配
+type (
+	wxrChannel struct {
+		Categories []wxrCategory `xml:"channel>category"`
+		Items      []wxrItem     `xml:"channel>item"`
+	}
+
+	wxrCategory struct {
+		Name string `xml:"cat_name"`
+	}
+
+	wxrItem struct {
+		Title    string       `xml:"title"`
+		PubDate  string       `xml:"pubDate"`
+		Creator  string       `xml:"creator"`
+		Content  string       `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+		PostId   int          `xml:"post_id"`
+		DateGmt  string       `xml:"post_date_gmt"`
+		Date     string       `xml:"post_date"`
+		Status   string       `xml:"status"`
+		PostType string       `xml:"post_type"`
+		Terms    []wxrTerm    `xml:"category"`
+		Comments []wxrComment `xml:"comment"`
+	}
+
+	wxrTerm struct {
+		Domain string `xml:"domain,attr"`
+		Name   string `xml:",chardata"`
+	}
+
+	wxrComment struct {
+		Id       int    `xml:"comment_id"`
+		Author   string `xml:"comment_author"`
+		DateGmt  string `xml:"comment_date_gmt"`
+		Date     string `xml:"comment_date"`
+		Content  string `xml:"comment_content"`
+		Approved string `xml:"comment_approved"`
+		Type     string `xml:"comment_type"`
+	}
+)
+
+func (wxrImporter) format() string {
+	return "wxr"
+}
+
+func (wxrImporter) detect(src *importSource) bool {
+	return src.data != nil && bytes.Contains(src.data, []byte("<rss")) &&
+		bytes.Contains(src.data, []byte("wordpress.org/export/"))
+}
+
+// 文章作为主题与一楼，评论依次作为后续楼层，未审核的评论进入待审核队列
+func (wxrImporter) load(src *importSource, opt *importOptions) (*importSet, error) {
+	var ch wxrChannel
+	err := xml.Unmarshal(src.data, &ch)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &importSet{}
+	seen := make(map[string]bool)
+	addMode := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			set.modes = append(set.modes, importMode{name: name, pub: true})
+		}
+	}
+	for _, c := range ch.Categories {
+		addMode(html.UnescapeString(c.Name))
+	}
+
+	for _, item := range ch.Items {
+		source := fmt.Sprintf("post %d %q", item.PostId, item.Title)
+		if item.PostType != "post" {
+			set.skipped = append(set.skipped, source+": "+item.PostType)
+			continue
+		}
+
+		t := importTopic{
+			source:    source,
+			mode:      opt.mode,
+			title:     strings.TrimSpace(html.UnescapeString(item.Title)),
+			createdAt: wxrTime(item.DateGmt, item.Date, item.PubDate),
+		}
+		if t.title == "" {
+			t.title = "(untitled)"
+		}
+
+		switch item.Status {
+		case "publish":
+			t.status = statusPublished
+		case "future":
+			t.status = statusScheduled
+			t.publishAt = &t.createdAt
+		case "draft", "pending", "private", "auto-draft":
+			t.status = statusDraft
+		default:
+			set.skipped = append(set.skipped, source+": status "+item.Status)
+			continue
+		}
+
+		// 第一个分类作为版块
+		categorized := false
+		for _, term := range item.Terms {
+			name := strings.TrimSpace(html.UnescapeString(term.Name))
+			switch term.Domain {
+			case "category":
+				if !categorized {
+					t.mode = name
+					categorized = true
+				}
+			case "post_tag":
+				t.tags = append(t.tags, name)
+			}
+		}
+		addMode(t.mode)
+
+		t.posts = append(t.posts, importPost{
+			updatedAt: t.createdAt,
+			content:   htmlToMarkdown(item.Content),
+		})
+
+		for _, c := range item.Comments {
+			comment := fmt.Sprintf("%s comment %d", source, c.Id)
+			if c.Type != "" && c.Type != "comment" {
+				set.skipped = append(set.skipped, comment+": "+c.Type)
+				continue
+			}
+
+			p := importPost{
+				author:    strings.TrimSpace(c.Author),
+				updatedAt: wxrTime(c.DateGmt, c.Date, ""),
+				content:   htmlToMarkdown(c.Content),
+			}
+			if p.author == "" {
+				p.author = "anonymous"
+			}
+			switch c.Approved {
+			case "1":
+				p.status = postApproved
+			case "0":
+				p.status = postPending
+			default:
+				set.skipped = append(set.skipped, comment+": approved "+c.Approved)
+				continue
+			}
+			t.posts = append(t.posts, p)
+		}
+
+		set.topics = append(set.topics, t)
+	}
+
+	return set, nil
+}
+
+// 优先使用 GMT 时间，草稿的 GMT 时间为 0000-00-00
+func wxrTime(gmt string, local string, rfc string) time.Time {
+	if t, err := time.Parse(time.DateTime, gmt); err == nil && t.Year() > 1 {
+		return t
+	}
+	if t, err := time.ParseInLocation(time.DateTime, local, time.Local); err == nil && t.Year() > 1 {
+		return t
+	}
+	if t, err := time.Parse(time.RFC1123Z, rfc); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+var (
+	mdSpecial  = regexp.MustCompile("([\\\\`*_\\[\\]<])")
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// 将文章 html 转为 Markdown，WordPress 以空行分段，保留原有换行
+func htmlToMarkdown(src string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(src), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: htmlatom.Body,
+	})
+	if err != nil {
+		return src
+	}
+
+	var b strings.Builder
+	for _, n := range nodes {
+		writeMarkdown(&b, n, "")
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(b.String(), "\n\n"))
+}
+
+func writeMarkdown(b *strings.Builder, n *html.Node, prefix string) {
+	children := func(prefix string) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeMarkdown(b, c, prefix)
+		}
+	}
+
+	switch n.Type {
+	case html.TextNode:
+		text := mdSpecial.ReplaceAllString(n.Data, `\$1`)
+		b.WriteString(strings.ReplaceAll(text, "\n", "\n"+prefix))
+		return
+	case html.ElementNode:
+	default:
+		children(prefix)
+		return
+	}
+
+	switch n.DataAtom {
+	case htmlatom.P, htmlatom.Div:
+		b.WriteString("\n\n" + prefix)
+		children(prefix)
+		b.WriteString("\n\n" + prefix)
+	case htmlatom.Br:
+		b.WriteString("  \n" + prefix)
+	case htmlatom.H1, htmlatom.H2, htmlatom.H3, htmlatom.H4, htmlatom.H5, htmlatom.H6:
+		level, _ := strconv.Atoi(n.Data[1:])
+		b.WriteString("\n\n" + prefix + strings.Repeat("#", level) + " ")
+		children(prefix)
+		b.WriteString("\n\n" + prefix)
+	case htmlatom.Strong, htmlatom.B:
+		b.WriteString("**")
+		children(prefix)
+		b.WriteString("**")
+	case htmlatom.Em, htmlatom.I:
+		b.WriteString("*")
+		children(prefix)
+		b.WriteString("*")
+	case htmlatom.Del, htmlatom.S:
+		b.WriteString("~~")
+		children(prefix)
+		b.WriteString("~~")
+	case htmlatom.Code:
+		b.WriteString("`" + textContent(n) + "`")
+	case htmlatom.Pre:
+		b.WriteString("\n\n" + prefix + "```\n" + prefix)
+		b.WriteString(strings.ReplaceAll(strings.TrimRight(textContent(n), "\n"), "\n", "\n"+prefix))
+		b.WriteString("\n" + prefix + "```\n\n" + prefix)
+	case htmlatom.Blockquote:
+		b.WriteString("\n\n" + prefix + "> ")
+		children(prefix + "> ")
+		b.WriteString("\n\n" + prefix)
+	case htmlatom.Ul, htmlatom.Ol:
+		b.WriteString("\n\n" + prefix)
+		i := 0
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom != htmlatom.Li {
+				continue
+			}
+			i++
+			marker := "- "
+			if n.DataAtom == htmlatom.Ol {
+				marker = strconv.Itoa(i) + ". "
+			}
+			b.WriteString(marker)
+			for cc := c.FirstChild; cc != nil; cc = cc.NextSibling {
+				writeMarkdown(b, cc, prefix+strings.Repeat(" ", len(marker)))
+			}
+			b.WriteString("\n" + prefix)
+		}
+		b.WriteString("\n" + prefix)
+	case htmlatom.A:
+		b.WriteString("[")
+		children(prefix)
+		b.WriteString("](" + attr(n, "href") + ")")
+	case htmlatom.Img:
+		b.WriteString("![" + mdSpecial.ReplaceAllString(attr(n, "alt"), `\$1`) + "](" + attr(n, "src") + ")")
+	case htmlatom.Hr:
+		b.WriteString("\n\n" + prefix + "---\n\n" + prefix)
+	case htmlatom.Script, htmlatom.Style, htmlatom.Iframe:
+	default:
+		children(prefix)
+	}
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return strings.ReplaceAll(a.Val, " ", "%20")
+		}
+	}
+	return ""
+}
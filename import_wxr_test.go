@@ -0,0 +1,120 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestHtmlToMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"text", "plain text", "plain text"},
+		{"paragraphs", "<p>a</p><p>b</p>", "a\n\nb"},
+		{"inline", "<strong>b</strong> <em>i</em> <del>d</del> <code>x*y</code>", "**b** *i* ~~d~~ `x*y`"},
+		{"escape", "a*b_c[d]", `a\*b\_c\[d\]`},
+		{"break", "a<br>b", "a  \nb"},
+		{"heading", "<h2>Title</h2>text", "## Title\n\ntext"},
+		{"link", `<a href="https://example.com">site</a>`, "[site](https://example.com)"},
+		{"image", `<img src="/a.png" alt="a_b">`, `![a\_b](/a.png)`},
+		{"unordered", "<ul><li>a</li><li>b</li></ul>", "- a\n- b"},
+		{"ordered", "<ol><li>a</li><li>b</li></ol>", "1. a\n2. b"},
+		{"quote", "<blockquote>a\nb</blockquote>", "> a\n> b"},
+		{"pre", "<pre>x := 1\ny</pre>", "```\nx := 1\ny\n```"},
+		{"rule", "a<hr>b", "a\n\n---\n\nb"},
+		{"script", "a<script>alert(1)</script>", "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := htmlToMarkdown(tt.src)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWxrImporter_load(t *testing.T) {
+	const wxr = `<?xml version="1.0"?>
+<rss xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+<wp:category><wp:cat_name>News</wp:cat_name></wp:category>
+<item>
+	<title>Hello</title>
+	<content:encoded><![CDATA[<p>body</p>]]></content:encoded>
+	<wp:post_id>1</wp:post_id>
+	<wp:post_date_gmt>2024-01-02 03:04:05</wp:post_date_gmt>
+	<wp:status>publish</wp:status>
+	<wp:post_type>post</wp:post_type>
+	<category domain="category">News</category>
+	<category domain="post_tag">go</category>
+	<wp:comment><wp:comment_id>10</wp:comment_id><wp:comment_author>a</wp:comment_author><wp:comment_content>ok</wp:comment_content><wp:comment_approved>1</wp:comment_approved></wp:comment>
+	<wp:comment><wp:comment_id>11</wp:comment_id><wp:comment_content>wait</wp:comment_content><wp:comment_approved>0</wp:comment_approved></wp:comment>
+	<wp:comment><wp:comment_id>12</wp:comment_id><wp:comment_content>buy</wp:comment_content><wp:comment_approved>spam</wp:comment_approved></wp:comment>
+	<wp:comment><wp:comment_id>13</wp:comment_id><wp:comment_content>gone</wp:comment_content><wp:comment_approved>trash</wp:comment_approved></wp:comment>
+	<wp:comment><wp:comment_id>14</wp:comment_id><wp:comment_content>ping</wp:comment_content><wp:comment_approved>1</wp:comment_approved><wp:comment_type>pingback</wp:comment_type></wp:comment>
+</item>
+<item>
+	<title>About</title>
+	<wp:post_id>2</wp:post_id>
+	<wp:status>publish</wp:status>
+	<wp:post_type>page</wp:post_type>
+</item>
+<item>
+	<title>Old</title>
+	<wp:post_id>3</wp:post_id>
+	<wp:status>trash</wp:status>
+	<wp:post_type>post</wp:post_type>
+</item>
+</channel>
+</rss>`
+
+	src := &importSource{data: []byte(wxr)}
+	if !(wxrImporter{}).detect(src) {
+		t.Fatal("not detected")
+	}
+	set, err := wxrImporter{}.load(src, &importOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantSkipped := []string{
+		`post 1 "Hello" comment 12: approved spam`,
+		`post 1 "Hello" comment 13: approved trash`,
+		`post 1 "Hello" comment 14: pingback`,
+		`post 2 "About": page`,
+		`post 3 "Old": status trash`,
+	}
+	slices.Sort(set.skipped)
+	if !slices.Equal(set.skipped, wantSkipped) {
+		t.Errorf("skipped %q, want %q", set.skipped, wantSkipped)
+	}
+
+	if len(set.topics) != 1 {
+		t.Fatalf("%d topics, want 1", len(set.topics))
+	}
+	topic := set.topics[0]
+	if topic.mode != "News" || !slices.Equal(topic.tags, []string{"go"}) || topic.status != statusPublished {
+		t.Errorf("topic %+v", topic)
+	}
+
+	tests := []struct {
+		author, status, content string
+	}{
+		{"", "", "body"},
+		{"a", postApproved, "ok"},
+		{"anonymous", postPending, "wait"},
+	}
+	if len(topic.posts) != len(tests) {
+		t.Fatalf("%d posts, want %d", len(topic.posts), len(tests))
+	}
+	for i, tt := range tests {
+		p := topic.posts[i]
+		if p.author != tt.author || p.status != tt.status || p.content != tt.content {
+			t.Errorf("post %d: got %+v, want %+v", i, p, tt)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unix 套接字地址前缀，如 unix:/run/sealog.sock
+const unixPrefix = "unix:"
+
+// 打开监听，unix 套接字启动前删除遗留文件
+func openListener(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, errors.New(path + " exists and is not a socket")
+		}
+		err = os.Remove(path)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// 反向代理与 sealog 通常不是同一用户，允许同组访问
+	err = os.Chmod(path, 0o660)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// unix 套接字连接没有对端地址，视为本机代理以便读取 X-Forwarded-For，套接字仅同组可访问
+func unixHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := net.SplitHostPort(r.RemoteAddr); err != nil {
+			r.RemoteAddr = "127.0.0.1:0"
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// certLoader 证书与私钥，文件修改后通过 reload 重新加载
+type certLoader struct {
+	mu       sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func newCertLoader(certFile string, keyFile string) (*certLoader, error) {
+	l := &certLoader{certFile: certFile, keyFile: keyFile}
+	_, err := l.reload()
+	return l, err
+}
+
+// 文件有变化时重新加载，返回是否已替换；加载失败时保留原证书
+func (l *certLoader) reload() (bool, error) {
+	mod, err := latestModTime(l.certFile, l.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	l.mu.RLock()
+	same := l.cert != nil && mod.Equal(l.modTime)
+	l.mu.RUnlock()
+	if same {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	l.mu.Lock()
+	l.cert = &cert
+	l.modTime = mod
+	l.mu.Unlock()
+
+	return true, nil
+}
+
+func (l *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.cert, nil
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
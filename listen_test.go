@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOpenListener(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "stale.sock")
+	l, err := openListener(unixPrefix + stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 关闭后保留套接字文件，模拟异常退出
+	l.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	l.Close()
+
+	regular := filepath.Join(dir, "file")
+	err = os.WriteFile(regular, nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		addr    string
+		network string
+		wantErr bool
+	}{
+		{"tcp", "127.0.0.1:0", "tcp", false},
+		{"unix", unixPrefix + filepath.Join(dir, "new.sock"), "unix", false},
+		{"stale socket", unixPrefix + stale, "unix", false},
+		{"not a socket", unixPrefix + regular, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := openListener(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer l.Close()
+
+			if got := l.Addr().Network(); got != tt.network {
+				t.Errorf("network %s, want %s", got, tt.network)
+			}
+			if tt.network == "unix" {
+				info, err := os.Stat(l.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if perm := info.Mode().Perm(); perm != 0o660 {
+					t.Errorf("mode %o, want 660", perm)
+				}
+			}
+		})
+	}
+}
+
+func TestUnixHandler(t *testing.T) {
+	tests := []struct {
+		remote string
+		want   string
+	}{
+		{"@", "127.0.0.1:0"},
+		{"", "127.0.0.1:0"},
+		{"192.0.2.1:1234", "192.0.2.1:1234"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remote, func(t *testing.T) {
+			var got string
+			h := unixHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 证书文件修改后重新加载，无效文件保留原证书
+func TestCertLoader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "a")
+
+	l, err := newCertLoader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		change   func()
+		reloaded bool
+		wantErr  bool
+		cn       string
+	}{
+		{"unchanged", func() {}, false, false, "a"},
+		{"renewed", func() { writeTestCert(t, certFile, keyFile, "b") }, true, false, "b"},
+		{"broken", func() { touchFile(t, certFile, []byte("broken")) }, false, true, "b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			reloaded, err := l.reload()
+			if reloaded != tt.reloaded || (err != nil) != tt.wantErr {
+				t.Fatalf("got (%v, %v), want (%v, wantErr %v)", reloaded, err, tt.reloaded, tt.wantErr)
+			}
+
+			cert, _ := l.getCertificate(nil)
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if leaf.Subject.CommonName != tt.cn {
+				t.Errorf("serving %q, want %q", leaf.Subject.CommonName, tt.cn)
+			}
+		})
+	}
+}
+
+func writeTestCert(t *testing.T, certFile string, keyFile string, cn string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	touchFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	touchFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// 覆盖已有文件时推后修改时间，避免两次写入落在同一时间精度内
+func touchFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+
+	old, statErr := os.Stat(path)
+	err := os.WriteFile(path, b, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statErr != nil {
+		return
+	}
+
+	next := old.ModTime().Add(time.Second)
+	err = os.Chtimes(path, next, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// unix 套接字追加的本机代理不能写入共享配置
+func TestInitializeRouter_proxies(t *testing.T) {
+	proxies := make([]string, 1, 4)
+	proxies[0] = "10.0.0.1"
+	cfg := config{listen: unixPrefix + "/run/sealog.sock", proxies: proxies}
+
+	initializeRouter(gin.New(), &cfg)
+
+	if len(cfg.proxies) != 1 || proxies[:2][1] != "" {
+		t.Errorf("config proxies modified: %q", proxies[:2])
+	}
+	if len(trustedNets) != 2 {
+		t.Errorf("trusted nets %v, want proxy and 127.0.0.1", trustedNets)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+// 不支持 flock 的平台不加锁
+func lockDataDir(dir string) (func(), error) {
+	return func() {}, nil
+}
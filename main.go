@@ -1,17 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -22,20 +27,36 @@ import (
 const defaultPort = 8080
 
 type config struct {
-	port   string
-	rootfs string
-	w      io.Writer
-	debug  bool
+	listen  string
+	rootfs  string
+	robots  string
+	logPath string
+	tlsCert string
+	tlsKey  string
+	origins []string
+	proxies []string
+	w       io.Writer
+	debug   bool
+
+	backupInterval time.Duration
+	backupDaily    int
+	backupWeekly   int
 }
 
 func main() {
 	cfg := initializeApplication()
 	commandExecute(cfg)
+	initializeLock(cfg)
 	initializeLogDrive(cfg)
 	initializeDbDrive(cfg)
+	requireSearchDrive()
 	initializeSrvDrive(cfg)
+	initializeFileDrive(cfg)
+	initializeRobots(cfg)
 	initializeAuth()
 	initializeHmac()
+	initializeTotp(cfg)
+	initializeSpam(cfg)
 	serverRun(cfg)
 }
 
@@ -46,30 +67,10 @@ func initializeApplication() *config {
 		os.Exit(1)
 	}
 
-	dir := filepath.Join(filepath.Dir(ex), "data")
-
-	info, err := os.Stat(dir)
-	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			err = os.MkdirAll(dir, 0o755)
-			if err != nil {
-				fmt.Println("error:", err)
-				os.Exit(1)
-			}
-		} else {
-			fmt.Println("error:", err)
-			os.Exit(1)
-		}
-	} else {
-		if !info.IsDir() {
-			fmt.Println("error:", dir+" not a directory")
-			os.Exit(1)
-		}
-	}
-
+	// 数据目录默认位于可执行文件旁，可由配置文件或环境变量修改
 	return &config{
-		port:   strconv.Itoa(defaultPort),
-		rootfs: dir,
+		listen: ":" + strconv.Itoa(defaultPort),
+		rootfs: filepath.Join(filepath.Dir(ex), "data"),
 	}
 }
 
@@ -84,9 +85,12 @@ func commandExecute(cfg *config) {
 		args := flag.NewFlagSet("server", flag.ExitOnError)
 		var port int
 		var debug bool
+		var robots string
 
-		args.IntVar(&port, "p", defaultPort, "server port")
+		args.IntVar(&port, "p", defaultPort, "server port, overrides the port of listen")
 		args.BoolVar(&debug, "debug", false, "debug mode")
+		args.StringVar(&robots, "robots", "", "robots.txt file (default <data>/robots.txt)")
+		path := configFlag(args)
 
 		err := args.Parse(os.Args[2:])
 		if err != nil {
@@ -99,13 +103,271 @@ func commandExecute(cfg *config) {
 			os.Exit(1)
 		}
 
-		cfg.port = strconv.Itoa(port)
+		initializeConfig(cfg, *path)
+
+		args.Visit(func(f *flag.Flag) {
+			if f.Name == "p" {
+				host, _, _ := net.SplitHostPort(cfg.listen)
+				cfg.listen = net.JoinHostPort(host, strconv.Itoa(port))
+			}
+		})
 		cfg.debug = debug
+		cfg.robots = robots
 
 	case "reset-password":
+		args := flag.NewFlagSet("reset-password", flag.ExitOnError)
+		var username string
+
+		args.StringVar(&username, "u", defaultUsername, "username")
+		path := configFlag(args)
+
+		err := args.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+
+		initializeConfig(cfg, *path)
+		initializeDbDrive(cfg)
+		initializeAuth()
+		password, err := resetPassword(username)
+		closeDb()
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		fmt.Println("new password:", password)
+		os.Exit(0)
+
+	case "purge":
+		args := flag.NewFlagSet("purge", flag.ExitOnError)
+		var days int
+
+		args.IntVar(&days, "days", 0, "only purge items deleted more than N days ago")
+		path := configFlag(args)
+
+		err := args.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+
+		if days < 0 {
+			fmt.Println("error:", "invalid days")
+			os.Exit(1)
+		}
+
+		initializeConfig(cfg, *path)
+		initializeDbDrive(cfg)
+		err = purgeTrash(time.Now().AddDate(0, 0, -days))
+		closeDb()
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+
+	case "rotate-key":
+		args := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+		path := configFlag(args)
+
+		err := args.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+
+		initializeConfig(cfg, *path)
+		initializeDbDrive(cfg)
+		initializeHmac()
+		kid, err := rotateHmacKey()
+		closeDb()
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		fmt.Println("new key id:", kid)
+		os.Exit(0)
+
+	case "disable-2fa":
+		args := flag.NewFlagSet("disable-2fa", flag.ExitOnError)
+		var username string
+
+		args.StringVar(&username, "u", defaultUsername, "username")
+		path := configFlag(args)
+
+		err := args.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+
+		initializeConfig(cfg, *path)
+		initializeDbDrive(cfg)
+		err = disableTotpByName(username)
+		closeDb()
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+
+	case "backup":
+		args := flag.NewFlagSet("backup", flag.ExitOnError)
+		var out string
+
+		args.StringVar(&out, "o", "sealog-"+time.Now().Format("20060102-150405")+".tar.gz", "output file")
+		path := configFlag(args)
+
+		err := args.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+
+		initializeConfig(cfg, *path)
+		m, err := createBackup(cfg.rootfs, out)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		for _, h := range m.Missing {
+			fmt.Println("warning: missing file", h)
+		}
+		fmt.Printf("backup written to %s (schema %d, %d files)\n", out, m.Schema, m.Files)
+		os.Exit(0)
+
+	case "restore":
+		args := flag.NewFlagSet("restore", flag.ExitOnError)
+		var in string
+
+		args.StringVar(&in, "i", "", "backup file created by 'sealog backup', or a .db snapshot from <data>/backups")
+		path := configFlag(args)
+
+		err := args.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+
+		if in == "" {
+			fmt.Println("error:", "missing -i")
+			os.Exit(1)
+		}
+
+		initializeConfig(cfg, *path)
+		m, err := restoreBackup(cfg.rootfs, in)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		if m.Files < 0 {
+			fmt.Printf("restored database snapshot %s (schema %d)\n", in, m.Schema)
+		} else {
+			fmt.Printf("restored backup from %s (schema %d, %d files)\n", m.CreatedAt.Format(time.RFC3339), m.Schema, m.Files)
+		}
+		os.Exit(0)
+
+	case "export":
+		args := flag.NewFlagSet("export", flag.ExitOnError)
+		var out string
+
+		args.StringVar(&out, "o", "sealog-export-"+time.Now().Format("20060102-150405")+".tar.gz", "output .tar.gz file or empty directory")
+		path := configFlag(args)
+
+		err := args.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+
+		initializeConfig(cfg, *path)
 		initializeDbDrive(cfg)
-		fmt.Println("new password:", generatePassword())
+		initializeFileDrive(cfg)
+		m, err := exportTo(out, time.Now())
 		closeDb()
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("exported %d modes, %d topics, %d files to %s\n", len(m.Modes), len(m.Topics), len(m.Files), out)
+		os.Exit(0)
+
+	case "import":
+		args := flag.NewFlagSet("import", flag.ExitOnError)
+		opt := importOptions{}
+
+		args.StringVar(&opt.format, "f", "auto", "format: auto, sealog, wxr or markdown")
+		args.StringVar(&opt.username, "u", defaultUsername, "author of imported topics whose user does not exist")
+		args.StringVar(&opt.mode, "mode", "Imported", "mode for posts without a category")
+		args.BoolVar(&opt.dryRun, "dry-run", false, "report what would be imported without writing")
+		args.Usage = func() {
+			fmt.Println("usage: sealog import [options] <file or directory>")
+			args.PrintDefaults()
+		}
+		path := configFlag(args)
+
+		err := args.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+
+		if args.NArg() != 1 {
+			args.Usage()
+			os.Exit(1)
+		}
+
+		initializeConfig(cfg, *path)
+		initializeDbDrive(cfg)
+		initializeFileDrive(cfg)
+		rep, err := runImport(args.Arg(0), &opt)
+		closeDb()
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		fmt.Print(rep)
+		os.Exit(0)
+
+	case "build":
+		args := flag.NewFlagSet("build", flag.ExitOnError)
+		var out, base string
+
+		args.StringVar(&out, "o", "public", "output directory, replaced on each build")
+		args.StringVar(&base, "url", "", "site url for feeds and sitemap (default site.url)")
+		path := configFlag(args)
+
+		err := args.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+
+		initializeConfig(cfg, *path)
+		if base == "" {
+			base = site.Url
+		}
+		if base == "" {
+			fmt.Println("warning: site.url is not set, feeds use relative links and sitemap.xml is skipped")
+		}
+		initializeDbDrive(cfg)
+		initializeFileDrive(cfg)
+		initializeRobots(cfg)
+		initializeTemplate()
+		rep, err := buildSite(out, base, time.Now())
+		closeDb()
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("built %d pages (%d topics, %d modes, %d tags, %d files) to %s\n", rep.Pages, rep.Topics, rep.Modes, rep.Tags, rep.Files, out)
+		if len(rep.Skipped) > 0 {
+			fmt.Printf("skipped %d:\n", len(rep.Skipped))
+			for _, s := range rep.Skipped {
+				fmt.Println("  " + s)
+			}
+		}
 		os.Exit(0)
 
 	case "-h", "--help":
@@ -118,32 +380,115 @@ func commandExecute(cfg *config) {
 	}
 }
 
+// 各子命令共用的 -config 参数
+func configFlag(args *flag.FlagSet) *string {
+	return args.String("config", "", "config file, .toml or .yaml (default $SEALOG_CONFIG)")
+}
+
 func serverRun(cfg *config) {
 	r := gin.Default()
-	initializeRouter(r)
+	initializeRouter(r, cfg)
 
 	srv := &http.Server{
-		Addr:    ":" + cfg.port,
+		Addr:    cfg.listen,
 		Handler: r,
 	}
+	if strings.HasPrefix(cfg.listen, unixPrefix) {
+		srv.Handler = unixHandler(r)
+	}
 
-	go func() {
-		fmt.Println("Listening on " + cfg.port)
+	var certs *certLoader
+	if cfg.tlsCert != "" {
+		var err error
+		certs, err = newCertLoader(cfg.tlsCert, cfg.tlsKey)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.getCertificate,
+		}
+	}
+
+	l, err := openListener(cfg.listen)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
 
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	go func() {
+		if certs != nil {
+			fmt.Println("Listening on " + cfg.listen + " (tls)")
+			err = srv.ServeTLS(l, "", "")
+		} else {
+			fmt.Println("Listening on " + cfg.listen)
+			err = srv.Serve(l)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalln("error:", err)
 		}
 	}()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	wg.Add(3)
+	go runScheduler(ctx, &wg)
+	go runHmacSync(ctx, &wg)
+	go runBackups(ctx, &wg, cfg)
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+
+	// SIGHUP 重新加载证书，用于证书续期
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for wait := true; wait; {
+		select {
+		case <-hup:
+			if certs == nil {
+				continue
+			}
+			changed, err := certs.reload()
+			if err != nil {
+				log.Println("error: reload certificate:", err)
+			} else if changed {
+				log.Println("certificate reloaded")
+			}
+		case <-quit:
+			wait = false
+		}
+	}
+
+	shutdown, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	err = srv.Shutdown(shutdown)
+	if err != nil {
+		log.Println("error:", err)
+	}
+
+	cancel()
+	wg.Wait()
 
 	closeDb()
 }
 
-func initializeRouter(r *gin.Engine) {
-	r.Use(corsMiddleware())
+func initializeRouter(r *gin.Engine, cfg *config) {
+	// gin 默认信任所有代理，任何客户端都能通过 X-Forwarded-For 伪造地址
+	proxies := slices.Clone(cfg.proxies)
+	if strings.HasPrefix(cfg.listen, unixPrefix) {
+		proxies = append(proxies, "127.0.0.1")
+	}
+	err := r.SetTrustedProxies(proxies)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	initializeTrustedNets(proxies)
+	if site.Url == "" {
+		log.Println("warning: site.url is not set, feed and sitemap links follow the request host and are not cached")
+	}
+
+	r.Use(corsMiddleware(cfg.origins))
 
 	api := r.Group("/api")
 	api.Use(authMiddleware())
@@ -153,28 +498,72 @@ func initializeRouter(r *gin.Engine) {
 	api.GET("/cv", getModes)
 	api.GET("/av/:aid", getTopicAndPosts)
 	api.GET("/cv/:cid", getTopicsByMode)
+	api.GET("/tag", getTags)
+	api.GET("/tag/:name", getTopicsByTag)
 	api.GET("/space", getAuthStat)
 	api.POST("/login", verifyAuthKey)
+	api.GET("/fl/token", getFormToken)
+	api.POST("/fl/guest", createGuestPost)
 
-	api.Use(protectMiddleware())
+	api.Use(protectMiddleware(roleReader, scopeReadPrivate, scopePostWrite, scopeModeAdmin))
 
-	auth := api.Group("/auth")
+	auth := api.Group("/auth", protectMiddleware(roleReader))
 	auth.POST("/change", changeAuthKey)
-
-	cv := api.Group("/cv")
+	auth.POST("/logout", logout)
+	auth.GET("/session", getSessions)
+	auth.POST("/session/revoke", revokeSession)
+	auth.POST("/rotate", protectMiddleware(roleOwner), rotateKey)
+	auth.POST("/totp/setup", setupTwoFactor)
+	auth.POST("/totp/enable", enableTwoFactor)
+	auth.POST("/totp/disable", disableTwoFactor)
+	auth.GET("/token", getApiTokens)
+	auth.POST("/token/create", createToken)
+	auth.POST("/token/delete", deleteToken)
+
+	user := api.Group("/user")
+	user.GET("/me", protectMiddleware(roleReader, scopeReadPrivate), getCurrentUser)
+	user.GET("", protectMiddleware(roleOwner), getUsers)
+	user.POST("/create", protectMiddleware(roleOwner), createUser)
+	user.POST("/update", protectMiddleware(roleOwner), updateUser)
+	user.POST("/delete", protectMiddleware(roleOwner), deleteUser)
+
+	cv := api.Group("/cv", protectMiddleware(roleEditor, scopeModeAdmin))
 	cv.POST("/create", createMode)
 	cv.POST("/update", updateMode)
 	cv.POST("/delete", deleteMode)
 
-	av := api.Group("/av")
+	av := api.Group("/av", protectMiddleware(roleEditor, scopePostWrite))
 	av.POST("/create", createTopic)
 	av.POST("/update", updateTopic)
 	av.POST("/delete", deleteTopic)
 
-	fl := api.Group("/fl")
+	fl := api.Group("/fl", protectMiddleware(roleEditor, scopePostWrite))
 	fl.POST("/create", createPost)
 	fl.POST("/update", updatePost)
 	fl.POST("/delete", deletePost)
+	fl.GET("/revision", getPostRevisions)
+	fl.GET("/diff", getPostDiff)
+	fl.POST("/restore", restorePost)
+
+	md := api.Group("/moderation", protectMiddleware(roleEditor, scopeModeAdmin))
+	md.GET("", getPendingPosts)
+	md.POST("/approve", approvePendingPosts)
+	md.POST("/reject", rejectPendingPosts)
+	md.GET("/spam", getSpam)
+	md.POST("/spam/release", releaseCaughtSpam)
+	md.POST("/spam/delete", deleteCaughtSpam)
+
+	api.POST("/upload", protectMiddleware(roleEditor, scopePostWrite), uploadFile)
+
+	api.GET("/backup", protectMiddleware(roleOwner, scopeModeAdmin), getBackupStatus)
+	api.GET("/export", protectMiddleware(roleOwner, scopeReadPrivate), exportBlog)
+
+	tr := api.Group("/trash", protectMiddleware(roleEditor, scopeModeAdmin))
+	tr.GET("", getTrash)
+	tr.POST("/cv/restore", restoreMode)
+	tr.POST("/av/restore", restoreTopic)
+	tr.POST("/fl/restore", restoreDeletedPost)
+	tr.POST("/purge", protectMiddleware(roleOwner), purgeDeleted)
 
 	s := r.Group("/")
 	s.Use(cacheMiddleware())
@@ -183,11 +572,22 @@ func initializeRouter(r *gin.Engine) {
 	h := r.Group("/")
 	h.Use(authMiddleware())
 	renderHtml(h)
+
+	f := r.Group("/")
+	feed(f)
+	sitemap(f)
 }
 
-func corsMiddleware() gin.HandlerFunc {
+// origins 为空或包含 * 时允许所有来源
+func corsMiddleware(origins []string) gin.HandlerFunc {
+	all := len(origins) == 0 || slices.Contains(origins, "*")
+	if all {
+		origins = nil
+	}
+
 	return cors.New(cors.Config{
-		AllowAllOrigins:  true,
+		AllowAllOrigins:  all,
+		AllowOrigins:     origins,
 		AllowMethods:     []string{"*"},
 		AllowHeaders:     []string{"*"},
 		AllowCredentials: false,
@@ -197,5 +597,13 @@ func corsMiddleware() gin.HandlerFunc {
 
 const man = `% command:
   server          start httpserver (use 'server -h' view help)
-  reset-password  reset admin password
+  reset-password  reset user password (use 'reset-password -h' view help)
+  purge           empty the recycle bin (use 'purge -h' view help)
+  rotate-key      rotate the token signing key (use 'rotate-key -h' view help)
+  disable-2fa     disable two-factor login (use 'disable-2fa -h' view help)
+  backup          write an online snapshot of the data directory (use 'backup -h' view help)
+  restore         replace the data directory from a backup (use 'restore -h' view help)
+  export          export content as Markdown with a manifest (use 'export -h' view help)
+  import          import from WordPress, Hugo, Jekyll or a sealog export (use 'import -h' view help)
+  build           render public content to a static site (use 'build -h' view help)
 `
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"log"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"gorm.io/gorm"
+)
+
+// 未开启 WithUnsafe，原始 html 与 javascript: 等危险链接均不会输出
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+)
+
+func renderMarkdown(src string) string {
+	var buf bytes.Buffer
+
+	err := md.Convert([]byte(src), &buf)
+	if err != nil {
+		log.Println("error:", err)
+		return "<pre>" + html.EscapeString(src) + "</pre>"
+	}
+
+	return buf.String()
+}
+
+// 补全升级前未渲染的楼层
+func initializeMarkdown() {
+	var posts []Post
+	err := db.Where("content_html = ''").Select("id", "content").Find(&posts).Error
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+
+	for _, p := range posts {
+		err = db.Model(&Post{}).Session(&gorm.Session{SkipHooks: true}).Where("id = ?", p.Id).
+			UpdateColumn("content_html", renderMarkdown(p.Content)).Error
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// 原始 html 与危险链接不会输出
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		want   string
+		reject string
+	}{
+		{"emphasis", "**b** *i*", "<strong>b</strong> <em>i</em>", ""},
+		{"heading", "# t", "<h1>t</h1>", ""},
+		{"link", "[a](https://example.com)", `<a href="https://example.com">a</a>`, ""},
+		{"autolink", "see https://example.com", `<a href="https://example.com">`, ""},
+		{"table", "| a |\n| - |\n| b |", "<table>", ""},
+		{"strikethrough", "~~d~~", "<del>d</del>", ""},
+		{"code escaped", "`<b>`", "<code>&lt;b&gt;</code>", ""},
+		{"script", "<script>alert(1)</script>", "raw HTML omitted", "<script"},
+		{"inline html", "a <img src=x onerror=alert(1)> b", "raw HTML omitted", "onerror"},
+		{"javascript link", "[x](javascript:alert(1))", `<a href="">x</a>`, "javascript:"},
+		{"javascript image", "![x](javascript:alert(1))", "<img", "javascript:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderMarkdown(tt.src)
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if tt.reject != "" && strings.Contains(got, tt.reject) {
+				t.Errorf("got %q, contains %q", got, tt.reject)
+			}
+		})
+	}
+}
+
+// 升级前未渲染的楼层在启动时补全
+func TestInitializeMarkdown(t *testing.T) {
+	topic := newTestTopic(t, true)
+	post := Post{TopicId: topic.Id, Content: "**old**"}
+	err := post.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Model(&post).UpdateColumn("content_html", "").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initializeMarkdown()
+
+	var got Post
+	db.Where("id = ?", post.Id).Take(&got)
+	if !strings.Contains(got.ContentHtml, "<strong>old</strong>") {
+		t.Errorf("content_html %q", got.ContentHtml)
+	}
+}
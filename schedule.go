@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 主题状态，draft 与 scheduled 仅作者与 editor 以上角色可见
+const (
+	statusDraft     = "draft"
+	statusScheduled = "scheduled"
+	statusPublished = "published"
+)
+
+// 定时发布检查间隔
+const scheduleInterval = time.Minute
+
+// 定时发布必须指定 publish_at，时间统一保存为 UTC 以便与当前时间比较
+func checkSchedule(status string, publishAt **time.Time) error {
+	if *publishAt != nil {
+		t := (*publishAt).UTC()
+		*publishAt = &t
+	}
+	if status == statusScheduled && *publishAt == nil {
+		return errors.New("missing publish_at")
+	}
+
+	return nil
+}
+
+// sql 中主题对读者已发布的条件，t 为主题表的名称或别名。
+// 到期但定时任务尚未处理的主题同样视为已发布，sealog build 不运行定时任务，也不修改数据
+func publishedCond(t string) string {
+	return fmt.Sprintf("(%[1]s.status = '%[2]s' OR %[1]s.status = '%[3]s' AND julianday(%[1]s.publish_at) <= julianday('now'))",
+		t, statusPublished, statusScheduled)
+}
+
+// 与 publishedCond 一致
+func (t *Topic) published(now time.Time) bool {
+	return t.Status == statusPublished || t.Status == statusScheduled && t.PublishAt != nil && !t.PublishAt.After(now)
+}
+
+// 到期的定时主题改为已发布，发布时间作为 created_at
+func publishScheduled(now time.Time) (int64, error) {
+	tx := db.Model(&Topic{}).Session(&gorm.Session{SkipHooks: true}).
+		Where("status = ?", statusScheduled).Where("publish_at <= ?", now.UTC()).
+		Updates(map[string]interface{}{
+			"status":     statusPublished,
+			"created_at": gorm.Expr("publish_at"),
+		})
+
+	return tx.RowsAffected, tx.Error
+}
+
+func runScheduler(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := publishScheduled(time.Now())
+		if err != nil {
+			log.Println("error:", err)
+		} else if n > 0 {
+			log.Println("published", n, "scheduled topics")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckSchedule(t *testing.T) {
+	at := time.Date(2024, 1, 1, 8, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	tests := []struct {
+		name      string
+		status    string
+		publishAt *time.Time
+		wantErr   bool
+	}{
+		{"scheduled", statusScheduled, &at, false},
+		{"scheduled without time", statusScheduled, nil, true},
+		{"draft", statusDraft, nil, false},
+		{"published with time", statusPublished, &at, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.publishAt
+			err := checkSchedule(tt.status, &p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, wantErr %v", err, tt.wantErr)
+			}
+			if p == nil {
+				return
+			}
+			if p.Location() != time.UTC || !p.Equal(at) {
+				t.Errorf("publish_at %v, want %v in UTC", p, at)
+			}
+		})
+	}
+}
+
+func TestTopic_published(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Second), now.Add(time.Second)
+	tests := []struct {
+		status    string
+		publishAt *time.Time
+		want      bool
+	}{
+		{statusPublished, nil, true},
+		{statusDraft, &past, false},
+		{statusScheduled, &past, true},
+		{statusScheduled, &now, true},
+		{statusScheduled, &future, false},
+		{statusScheduled, nil, false},
+	}
+
+	for _, tt := range tests {
+		topic := Topic{Status: tt.status, PublishAt: tt.publishAt}
+		if got := topic.published(now); got != tt.want {
+			t.Errorf("%s %v: got %v, want %v", tt.status, tt.publishAt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"html"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// 全文检索依赖 FTS5，须以 -tags sqlite_fts5 编译，缺少时服务端启动失败而不是退回无排序的匹配
+const ftsMissing = "sqlite is built without FTS5, rebuild with: go build -tags sqlite_fts5"
+
+// 当前 sqlite 是否支持 FTS5
+var ftsEnabled bool
+
+// trigram 分词支持中文子串匹配，检索词至少 3 个字符
+const ftsTable = `CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+	title, content, topic_id UNINDEXED, tokenize = 'trigram'
+)`
+
+var ftsTriggers = map[string]string{
+	"posts_fts_ai": `AFTER INSERT ON posts BEGIN
+		INSERT INTO posts_fts(rowid, title, content, topic_id)
+		SELECT new.id, title, new.content, new.topic_id FROM topics WHERE id = new.topic_id;
+	END`,
+	"posts_fts_au": `AFTER UPDATE OF content ON posts BEGIN
+		UPDATE posts_fts SET content = new.content WHERE rowid = new.id;
+	END`,
+	"posts_fts_ad": `AFTER DELETE ON posts BEGIN
+		DELETE FROM posts_fts WHERE rowid = old.id;
+	END`,
+	"topics_fts_au": `AFTER UPDATE OF title ON topics BEGIN
+		UPDATE posts_fts SET title = new.title WHERE rowid IN (SELECT id FROM posts WHERE topic_id = new.id);
+	END`,
+}
+
+const ftsRebuild = `INSERT INTO posts_fts(rowid, title, content, topic_id)
+	SELECT p.id, t.title, p.content, p.topic_id FROM posts AS p JOIN topics AS t ON t.id = p.topic_id`
+
+// 每个主题只取得分最高的楼层，标题权重高于内容
+const ftsQuery = `WITH m AS MATERIALIZED (
+	SELECT rowid AS post_id, topic_id, bm25(posts_fts, 10.0, 1.0) AS score,
+		snippet(posts_fts, -1, char(2), char(3), '…', 16) AS snippet
+	FROM posts_fts WHERE posts_fts MATCH ?
+		AND rowid IN (SELECT id FROM posts WHERE deleted_at IS NULL AND status = 'approved')
+)
+SELECT t.id, t.title, t.mode_id, p.floor, m.snippet FROM (
+	SELECT *, ROW_NUMBER() OVER (PARTITION BY topic_id ORDER BY score) AS n FROM m
+) AS m
+JOIN topics AS t ON t.id = m.topic_id
+JOIN posts AS p ON p.id = m.post_id
+WHERE m.n = 1 AND t.deleted_at IS NULL %s
+ORDER BY m.score LIMIT ? OFFSET ?`
+
+// 缺少 FTS5 时不检索的子命令仍可运行，移除触发器使写入不依赖索引表，服务端下次启动时重建索引
+func initializeSearchDrive() {
+	ftsEnabled = db.Exec("SELECT fts5_source_id()").Error == nil
+
+	var n int64
+	err := db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ?",
+		slices.Collect(maps.Keys(ftsTriggers))).Scan(&n).Error
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+
+	if !ftsEnabled {
+		if n == 0 {
+			return
+		}
+		for name := range ftsTriggers {
+			err = db.Exec("DROP TRIGGER IF EXISTS " + name).Error
+			if err != nil {
+				log.Fatalln("error:", err)
+			}
+		}
+		log.Println("warning:", ftsMissing+", the search index will be rebuilt on the next server start")
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(ftsTable).Error
+		if err != nil {
+			return err
+		}
+
+		for name, body := range ftsTriggers {
+			err = tx.Exec("CREATE TRIGGER IF NOT EXISTS " + name + " " + body).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// 新建索引，或触发器曾被移除导致索引过期
+		if int(n) < len(ftsTriggers) {
+			err = tx.Exec("DELETE FROM posts_fts").Error
+			if err != nil {
+				return err
+			}
+			return tx.Exec(ftsRebuild).Error
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+}
+
+// 服务端提供检索，缺少 FTS5 时拒绝启动
+func requireSearchDrive() {
+	if !ftsEnabled {
+		log.Fatalln("error:", ftsMissing)
+	}
+}
+
+// 每个词单独加引号避免 FTS5 语法错误，不足 3 个字符的词无法被 trigram 匹配
+func ftsMatch(q string) string {
+	var terms []string
+	for _, f := range strings.Fields(q) {
+		if utf8.RuneCountInString(f) >= 3 {
+			terms = append(terms, `"`+strings.ReplaceAll(f, `"`, `""`)+`"`)
+		}
+	}
+	if len(terms) == 0 {
+		return `"` + strings.ReplaceAll(q, `"`, `""`) + `"`
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// snippet 以 \x02 \x03 标记命中位置，转义后替换为 <mark>
+func highlight(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, "\x02", "<mark>")
+	return strings.ReplaceAll(s, "\x03", "</mark>")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestFtsMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		q    string
+		want string
+	}{
+		{"single", "sqlite", `"sqlite"`},
+		{"fields", "full text search", `"full" "text" "search"`},
+		{"short dropped", "go sqlite", `"sqlite"`},
+		{"all short", "go", `"go"`},
+		{"quote", `a"bc`, `"a""bc"`},
+		{"syntax", "NOT OR AND*", `"NOT" "AND*"`},
+		{"cjk", "全文检索", `"全文检索"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ftsMatch(tt.q); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"plain", "plain"},
+		{"a \x02hit\x03 b", "a <mark>hit</mark> b"},
+		{"<b>\x02x\x03</b>", "&lt;b&gt;<mark>x</mark>&lt;/b&gt;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := highlight(tt.src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 在独立的数据库中初始化检索，结束后恢复共享的 db
+func withSearchDb(t *testing.T) {
+	t.Helper()
+
+	saved := db
+	var err error
+	db, err = gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "data.db")), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		closeDb()
+		db = saved
+	})
+
+	err = db.AutoMigrate(&Mode{}, &Topic{}, &Post{}, &User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Create(&Mode{Name: "search", Pub: true}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 缺少 FTS5 时移除残留的索引触发器，写入楼层不再依赖索引表
+func TestInitializeSearchDrive_withoutFts(t *testing.T) {
+	withSearchDb(t)
+	if db.Exec("SELECT fts5_source_id()").Error == nil {
+		t.Skip("sqlite is built with FTS5")
+	}
+
+	for name, body := range ftsTriggers {
+		err := db.Exec("CREATE TRIGGER " + name + " " + body).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	initializeSearchDrive()
+	if ftsEnabled {
+		t.Fatal("ftsEnabled without FTS5")
+	}
+
+	var n int64
+	db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'trigger'").Scan(&n)
+	if n != 0 {
+		t.Errorf("%d triggers left", n)
+	}
+
+	err := db.Create(&Topic{Title: "t", ModeId: 1}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = (&Post{TopicId: 1, Content: "content"}).create()
+	if err != nil {
+		t.Errorf("insert post: %v", err)
+	}
+}
+
+func TestQueryTopicsBySearch(t *testing.T) {
+	withSearchDb(t)
+	initializeSearchDrive()
+	if !ftsEnabled {
+		t.Skip(ftsMissing)
+	}
+
+	for _, s := range [][2]string{{"sqlite notes", "full text search with trigram"}, {"other", "nothing here"}} {
+		topic := Topic{Title: s[0], ModeId: 1}
+		err := topic.create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = (&Post{TopicId: topic.Id, Content: s[1]}).create()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		q    string
+		want []int
+	}{
+		{"sqlite", []int{1}},
+		{"trigram", []int{1}},
+		{"nothing", []int{2}},
+		{"absent", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			var res []resSearch
+			err, _, _ := queryTopicsBySearch(&res, -1, tt.q, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int
+			for _, r := range res {
+				got = append(got, r.Id)
+			}
+			if len(got) != len(tt.want) || len(got) > 0 && got[0] != tt.want[0] {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
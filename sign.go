@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"log"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -14,31 +17,138 @@ import (
 )
 
 type (
-	// Auth 认证密码
+	// User 用户
+	User struct {
+		Id          int       `gorm:"primaryKey"           json:"id"`
+		Username    string    `gorm:"uniqueIndex;not null" json:"username"`
+		Hash        string    `gorm:"not null"             json:"-"`
+		Role        string    `gorm:"not null"             json:"role"`
+		CreatedAt   time.Time `gorm:"autoCreateTime"       json:"created_at"`
+		TotpEnabled bool      `gorm:"default:false"        json:"totp"`
+		TotpSecret  string    `gorm:"not null;default:''"  json:"-"` // AES-GCM 加密
+		TotpLast    int64     `gorm:"not null;default:0"   json:"-"` // 最近使用的时间步，防止重放
+	}
+
+	// Auth 旧版单一认证密码，仅用于迁移至 User
 	Auth struct {
 		Id   int `gorm:"primaryKey"`
 		Hash string
 	}
 
-	// Hmac 签名密钥
+	// Session 登录会话，id 即 token 的 jti，删除即吊销
+	Session struct {
+		Id        string    `gorm:"primaryKey"     json:"id"`
+		UserId    int       `gorm:"index;not null" json:"user_id"`
+		Ip        string    `gorm:"not null"       json:"ip"`
+		UserAgent string    `gorm:"not null"       json:"user_agent"`
+		CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+		ExpiresAt time.Time `gorm:"index;not null" json:"expires_at"`
+		Current   bool      `gorm:"-"              json:"current"`
+	}
+
+	// Hmac 签名密钥，最新一条用于签名
 	Hmac struct {
-		Id  int `gorm:"primaryKey"`
-		Key string
+		Id        int `gorm:"primaryKey"`
+		Key       string
+		RetiredAt *time.Time `gorm:"index"` // 被新密钥取代的时间
 	}
 )
 
-var hmacKey []byte
+// 用户角色，权限依次递增
+const (
+	roleReader = "reader"
+	roleEditor = "editor"
+	roleOwner  = "owner"
+)
+
+var roleLevel = map[string]int{
+	roleReader: 1,
+	roleEditor: 2,
+	roleOwner:  3,
+}
+
+// 首次启动创建的 owner 用户名
+const defaultUsername = "admin"
+
+// 用户不存在时参与比较的 bcrypt 哈希，使其与密码错误耗时相同，避免泄露用户名是否存在
+const dummyHash = "$2a$10$lnSD2aKuSeaPKUXG88dccOHz.VWcrTpqGGghK86Eo1jsHLtdGjrsC"
+
+// token 有效期，可由配置文件修改
+var tokenLifetime = 7 * 24 * time.Hour
+
+// 轮换后仍用于验证的旧密钥数量
+const hmacKeep = 3
+
+// 旧密钥在轮换后继续验证的时长，覆盖其签发的 token 有效期
+func hmacGrace() time.Duration {
+	return tokenLifetime + time.Hour
+}
+
+// 同步其他进程完成的密钥轮换的间隔
+const hmacSyncInterval = time.Minute
+
+// 遇到未知 kid 时重新读取密钥的最短间隔，避免伪造的 kid 频繁查询数据库
+const hmacReloadInterval = time.Second
+
+var (
+	hmacMu       sync.RWMutex
+	hmacKid      int
+	hmacKey      []byte
+	hmacKeys     map[int][]byte
+	hmacLoadedAt time.Time
+)
 
 func initializeAuth() {
-	_, err := getAuthHash()
+	var n int64
+	err := db.Model(&User{}).Count(&n).Error
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	if n > 0 {
+		return
+	}
+
+	owner := User{
+		Username: defaultUsername,
+		Role:     roleOwner,
+	}
+
+	// 沿用旧版密码，否则生成默认密码
+	var password string
+	owner.Hash, err = getAuthHash()
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			password := generatePassword()
-			log.Println("default password:", password)
-		} else {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Fatalln("error:", err)
+		}
+		password = generatePassword()
+		owner.Hash, err = cryptoPassword(password)
+		if err != nil {
 			log.Fatalln("error:", err)
 		}
 	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&owner).Error
+		if err != nil {
+			return err
+		}
+
+		// 已有内容均由原管理员发布
+		err = tx.Model(&Topic{}).Session(&gorm.Session{SkipHooks: true}).Unscoped().
+			Where("user_id = 0").UpdateColumn("user_id", owner.Id).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Post{}).Session(&gorm.Session{SkipHooks: true}).Unscoped().
+			Where("user_id = 0").UpdateColumn("user_id", owner.Id).Error
+	})
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+
+	if password != "" {
+		log.Println("default password:", password)
+	}
 }
 
 func getAuthHash() (string, error) {
@@ -48,33 +158,61 @@ func getAuthHash() (string, error) {
 	return a.Hash, err
 }
 
-func addAuthHash(str string) error {
-	hash, err := cryptoPassword(str)
+func generatePassword() string {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// 重置指定用户的密码，返回新密码
+func resetPassword(username string) (string, error) {
+	password := generatePassword()
+
+	hash, err := cryptoPassword(password)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	a := Auth{
-		Hash: hash,
+	var user User
+	err = db.Where("username = ?", username).Select("id").Take(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", errors.New("user not found")
+	}
+	if err != nil {
+		return "", err
 	}
 
-	return db.Create(&a).Error
+	return password, db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Update("hash", hash).Error
+		if err != nil {
+			return err
+		}
+		return revokeSessions(tx, user.Id)
+	})
 }
 
-func generatePassword() string {
-	b := make([]byte, 3)
-	_, err := rand.Read(b)
+func setUserPassword(uid int, password string) error {
+	hash, err := cryptoPassword(password)
 	if err != nil {
-		log.Fatalln("error:", err)
+		return err
 	}
 
-	password := hex.EncodeToString(b)
-	err = addAuthHash(password)
-	if err != nil {
-		log.Fatalln("error:", err)
-	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", uid).Update("hash", hash).Error
+		if err != nil {
+			return err
+		}
+		return revokeSessions(tx, uid)
+	})
+}
 
-	return password
+// 吊销用户的全部会话
+func revokeSessions(tx *gorm.DB, uid int) error {
+	return tx.Where("user_id = ?", uid).Delete(&Session{}).Error
 }
 
 func cryptoPassword(password string) (string, error) {
@@ -87,40 +225,175 @@ func verifyPassword(hash, password string) bool {
 }
 
 func initializeHmac() {
-	key, err := getHmacKey()
+	err := loadHmacKeys()
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = addHmacKey()
+	}
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			err = addHmacKey()
-			if err != nil {
-				log.Fatalln("error:", err)
-			}
-			return
-		} else {
-			log.Fatalln("error:", err)
-		}
+		log.Fatalln("error:", err)
 	}
+}
 
-	hmacKey, err = base64.RawURLEncoding.DecodeString(key)
+// 读取当前密钥及仍在宽限期内的旧密钥
+func loadHmacKeys() error {
+	var rows []Hmac
+	err := db.Where("retired_at IS NULL OR retired_at > ?", time.Now().Add(-hmacGrace())).
+		Order("id DESC").Limit(hmacKeep + 1).Find(&rows).Error
 	if err != nil {
-		log.Fatalln("error:", err)
+		return err
+	}
+	if len(rows) == 0 || rows[0].RetiredAt != nil {
+		return gorm.ErrRecordNotFound
 	}
+
+	keys := make(map[int][]byte, len(rows))
+	for _, h := range rows {
+		keys[h.Id], err = base64.RawURLEncoding.DecodeString(h.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	hmacMu.Lock()
+	defer hmacMu.Unlock()
+	hmacKid, hmacKey, hmacKeys = rows[0].Id, keys[rows[0].Id], keys
+	hmacLoadedAt = time.Now()
+
+	return nil
 }
 
-func getHmacKey() (string, error) {
-	var h Hmac
+// 定期读取密钥，rotate-key 命令在其他进程中轮换后，服务随即改用新密钥签发
+func runHmacSync(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(hmacSyncInterval)
+	defer ticker.Stop()
 
-	err := db.Last(&h).Error
-	return h.Key, err
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := loadHmacKeys()
+		if err != nil {
+			log.Println("error:", err)
+		}
+	}
+}
+
+// kid 比当前密钥新时可能来自其他进程的轮换，重新读取后返回是否已知
+func reloadHmacKeys(kid int) bool {
+	hmacMu.RLock()
+	stale := kid > hmacKid && time.Since(hmacLoadedAt) >= hmacReloadInterval
+	hmacMu.RUnlock()
+	if !stale {
+		return false
+	}
+
+	err := loadHmacKeys()
+	if err != nil {
+		log.Println("error:", err)
+		return false
+	}
+
+	hmacMu.RLock()
+	defer hmacMu.RUnlock()
+	_, ok := hmacKeys[kid]
+	return ok
 }
 
 func addHmacKey() error {
-	hmacKey = generateHmacKey()
+	h := Hmac{
+		Key: base64.RawURLEncoding.EncodeToString(generateHmacKey()),
+	}
+
+	err := db.Create(&h).Error
+	if err != nil {
+		return err
+	}
+
+	return loadHmacKeys()
+}
 
+// 轮换签名密钥，返回新密钥 id
+func rotateHmacKey() (int, error) {
+	now := time.Now()
 	h := Hmac{
-		Key: base64.RawURLEncoding.EncodeToString(hmacKey),
+		Key: base64.RawURLEncoding.EncodeToString(generateHmacKey()),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Hmac{}).Where("retired_at IS NULL").Update("retired_at", now).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(&h).Error
+		if err != nil {
+			return err
+		}
+
+		return pruneHmacKeys(tx, now)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return h.Id, loadHmacKeys()
+}
+
+// 删除宽限期已过或超出保留数量的旧密钥
+func pruneHmacKeys(tx *gorm.DB, now time.Time) error {
+	err := tx.Where("retired_at < ?", now.Add(-hmacGrace())).Delete(&Hmac{}).Error
+	if err != nil {
+		return err
+	}
+
+	var keep []int
+	err = tx.Model(&Hmac{}).Order("id DESC").Limit(hmacKeep+1).Pluck("id", &keep).Error
+	if err != nil {
+		return err
 	}
 
-	return db.Create(&h).Error
+	return tx.Where("id NOT IN ?", keep).Delete(&Hmac{}).Error
+}
+
+// 返回当前签名密钥及其 id
+func currentHmacKey() (int, []byte) {
+	hmacMu.RLock()
+	defer hmacMu.RUnlock()
+
+	return hmacKid, hmacKey
+}
+
+// 返回 kid 对应的密钥，kid 为 0 时返回全部可用密钥
+func lookupHmacKeys(kid int) [][]byte {
+	if kid != 0 {
+		hmacMu.RLock()
+		_, ok := hmacKeys[kid]
+		hmacMu.RUnlock()
+		if !ok && !reloadHmacKeys(kid) {
+			return nil
+		}
+	}
+
+	hmacMu.RLock()
+	defer hmacMu.RUnlock()
+
+	if kid != 0 {
+		if key, ok := hmacKeys[kid]; ok {
+			return [][]byte{key}
+		}
+		return nil
+	}
+
+	keys := make([][]byte, 0, len(hmacKeys))
+	for _, key := range hmacKeys {
+		keys = append(keys, key)
+	}
+	return keys
 }
 
 func generateHmacKey() []byte {
@@ -133,20 +406,84 @@ func generateHmacKey() []byte {
 	return key
 }
 
-func encodeToken() (string, error) {
+// 签发 token 并记录会话
+func encodeToken(uid int, ip string, ua string) (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	session := Session{
+		Id:        hex.EncodeToString(b),
+		UserId:    uid,
+		Ip:        ip,
+		UserAgent: ua,
+		ExpiresAt: now.Add(tokenLifetime),
+	}
+
+	err = db.Where("expires_at < ?", now).Delete(&Session{}).Error
+	if err != nil {
+		return "", err
+	}
+	err = db.Create(&session).Error
+	if err != nil {
+		return "", err
+	}
+
 	claims := &jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ID:        session.Id,
+		Subject:   strconv.Itoa(uid),
+		ExpiresAt: jwt.NewNumericDate(session.ExpiresAt),
+		IssuedAt:  jwt.NewNumericDate(now),
 	}
 
+	kid, key := currentHmacKey()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(hmacKey)
+	token.Header["kid"] = strconv.Itoa(kid)
+	return token.SignedString(key)
 }
 
-func decodeToken(str string) bool {
-	token, err := jwt.Parse(str, func(token *jwt.Token) (interface{}, error) {
-		return hmacKey, nil
-	})
+// 返回 token 中的用户 id 与 jti，会话已吊销时无效
+func decodeToken(str string) (int, string, bool) {
+	var claims jwt.RegisteredClaims
+	token, err := jwt.ParseWithClaims(str, &claims, func(token *jwt.Token) (interface{}, error) {
+		// 未携带 kid 的旧 token 依次尝试全部可用密钥
+		var kid int
+		if s, ok := token.Header["kid"].(string); ok {
+			kid, _ = strconv.Atoi(s)
+			if kid == 0 {
+				return nil, errors.New("invalid kid")
+			}
+		}
+
+		keys := lookupHmacKeys(kid)
+		if len(keys) == 0 {
+			return nil, errors.New("unknown kid")
+		}
+
+		set := jwt.VerificationKeySet{}
+		for _, key := range keys {
+			set.Keys = append(set.Keys, key)
+		}
+		return set, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || token == nil || !token.Valid || claims.ID == "" {
+		return 0, "", false
+	}
+
+	uid, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, "", false
+	}
+
+	var n int64
+	err = db.Model(&Session{}).Where("id = ?", claims.ID).Where("user_id = ?", uid).
+		Where("expires_at > ?", time.Now()).Count(&n).Error
+	if err != nil || n == 0 {
+		return 0, "", false
+	}
 
-	return err == nil && token != nil && token.Valid
+	return uid, claims.ID, true
 }
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 不存在的用户与密码错误走同样开销的 bcrypt 比较
+func TestDummyHash(t *testing.T) {
+	cost, err := bcrypt.Cost([]byte(dummyHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost != bcrypt.DefaultCost {
+		t.Errorf("cost %d, want %d", cost, bcrypt.DefaultCost)
+	}
+	if verifyPassword(dummyHash, "") {
+		t.Error("dummy hash accepts empty password")
+	}
+}
+
+func TestProtectMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		uid    int
+		role   string
+		scopes []string // nil 表示登录会话
+		need   string
+		want   int
+	}{
+		{"guest", -1, "", nil, roleReader, 403},
+		{"reader", 1, roleReader, nil, roleReader, 200},
+		{"reader on editor route", 1, roleReader, nil, roleEditor, 403},
+		{"editor", 1, roleEditor, nil, roleEditor, 200},
+		{"owner", 1, roleOwner, nil, roleEditor, 200},
+		{"unknown role", 1, "admin", nil, roleReader, 403},
+		{"token with scope", 1, roleEditor, []string{scopePostWrite}, roleEditor, 200},
+		{"token without scope", 1, roleOwner, []string{scopeReadPrivate}, roleEditor, 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/", func(c *gin.Context) {
+				c.Set("uid", tt.uid)
+				c.Set("role", tt.role)
+				if tt.scopes != nil {
+					c.Set("scopes", tt.scopes)
+				}
+			}, protectMiddleware(tt.need, scopePostWrite), func(c *gin.Context) {
+				c.Status(200)
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAuthKey(t *testing.T) {
+	withTestDb(t, &User{}, &Session{}, &Hmac{})
+	initializeHmac()
+	t.Cleanup(func() {
+		guard = newLoginGuard()
+	})
+
+	for _, u := range []struct{ name, password string }{{defaultUsername, "admin-pw"}, {"editor", "editor-pw"}} {
+		hash, err := cryptoPassword(u.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Create(&User{Username: u.name, Hash: hash, Role: roleEditor}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"default username", `{"password":"admin-pw"}`, 200},
+		{"named user", `{"username":"editor","password":"editor-pw"}`, 200},
+		{"other user's password", `{"username":"editor","password":"admin-pw"}`, 401},
+		{"unknown user", `{"username":"nobody","password":"editor-pw"}`, 401},
+		{"no password", `{"username":"editor"}`, 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard = newLoginGuard()
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/api/login", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			verifyAuthKey(c)
+
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+// 不能删除或降级最后一个 owner
+func TestLastOwner(t *testing.T) {
+	withTestDb(t, &User{}, &Session{}, &ApiToken{})
+
+	tests := []struct {
+		name   string
+		owners int
+		handle gin.HandlerFunc
+		body   string
+		want   int
+	}{
+		{"demote last", 1, updateUser, `{"id":1,"role":"editor"}`, 400},
+		{"delete last", 1, deleteUser, `{"id":1}`, 400},
+		{"demote one of two", 2, updateUser, `{"id":1,"role":"editor"}`, 200},
+		{"delete one of two", 2, deleteUser, `{"id":1}`, 200},
+		{"missing", 1, deleteUser, `{"id":99}`, 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db.Where("1 = 1").Delete(&User{})
+			for i := 1; i <= tt.owners; i++ {
+				err := db.Create(&User{Id: i, Username: "owner" + strconv.Itoa(i), Hash: "-", Role: roleOwner}).Error
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			tt.handle(c)
+
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+// 注销、吊销与过期的会话对应的 token 均失效
+func TestDecodeToken_sessions(t *testing.T) {
+	withTestDb(t, &User{}, &Session{}, &Hmac{})
+	withHmac(t)
+
+	tests := []struct {
+		name   string
+		uid    int
+		revoke func(jti string) error
+		ok     bool
+	}{
+		{"valid", 1, func(string) error { return nil }, true},
+		{"logout", 1, func(jti string) error {
+			return db.Where("id = ?", jti).Delete(&Session{}).Error
+		}, false},
+		{"revoke all", 1, func(string) error {
+			return revokeSessions(db, 1)
+		}, false},
+		{"password changed", 1, func(string) error {
+			return setUserPassword(1, "new password")
+		}, false},
+		{"expired", 1, func(jti string) error {
+			return db.Model(&Session{}).Where("id = ?", jti).Update("expires_at", time.Now().Add(-time.Second)).Error
+		}, false},
+		{"other user revoked", 2, func(string) error {
+			return revokeSessions(db, 1)
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := encodeToken(tt.uid, "192.0.2.1", "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, jti, ok := decodeToken(token)
+			if !ok {
+				t.Fatal("fresh token rejected")
+			}
+
+			err = tt.revoke(jti)
+			if err != nil {
+				t.Fatal(err)
+			}
+			uid, _, ok := decodeToken(token)
+			if ok != tt.ok || ok && uid != tt.uid {
+				t.Errorf("got (%d, %v), want (%d, %v)", uid, ok, tt.uid, tt.ok)
+			}
+		})
+	}
+}
+
+// 只能吊销自己的会话
+func TestRevokeSession(t *testing.T) {
+	withTestDb(t, &User{}, &Session{}, &Hmac{})
+	withHmac(t)
+
+	token, err := encodeToken(1, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, jti, _ := decodeToken(token)
+
+	tests := []struct {
+		name string
+		uid  int
+		id   string
+		want int
+		ok   bool // 之后 token 是否仍有效
+	}{
+		{"other user", 2, jti, 404, true},
+		{"unknown", 1, "missing", 404, true},
+		{"own", 1, jti, 200, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/", strings.NewReader(`{"id":"`+tt.id+`"}`))
+			c.Request.Header.Set("Content-Type", "application/json")
+			c.Set("uid", tt.uid)
+			revokeSession(c)
+
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+			if _, _, ok := decodeToken(token); ok != tt.ok {
+				t.Errorf("token valid %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+// 使用独立的签名密钥，结束后恢复
+func withHmac(t *testing.T) {
+	t.Helper()
+
+	hmacMu.RLock()
+	kid, key, keys, loaded := hmacKid, hmacKey, hmacKeys, hmacLoadedAt
+	hmacMu.RUnlock()
+	t.Cleanup(func() {
+		hmacMu.Lock()
+		hmacKid, hmacKey, hmacKeys, hmacLoadedAt = kid, key, keys, loaded
+		hmacMu.Unlock()
+	})
+
+	initializeHmac()
+}
+
+// 轮换后旧密钥签发的 token 在宽限期内仍有效，超出宽限期或保留数量后失效
+func TestRotateHmacKey(t *testing.T) {
+	withTestDb(t, &User{}, &Session{}, &Hmac{})
+	withHmac(t)
+
+	signed := func() string {
+		token, err := encodeToken(1, "192.0.2.1", "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name    string
+		rotate  int           // 签发后轮换的次数
+		retired time.Duration // 轮换后将旧密钥的 retired_at 提前的时长
+		ok      bool
+	}{
+		{"current", 0, 0, true},
+		{"rotated once", 1, 0, true},
+		{"within keep", hmacKeep, 0, true},
+		{"beyond keep", hmacKeep + 1, 0, false},
+		{"grace expired", 1, hmacGrace() + time.Minute, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signed()
+			kid, _ := currentHmacKey()
+			for i := 0; i < tt.rotate; i++ {
+				_, err := rotateHmacKey()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.retired > 0 {
+				err := db.Model(&Hmac{}).Where("id = ?", kid).
+					Update("retired_at", time.Now().Add(-tt.retired)).Error
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = loadHmacKeys()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, _, ok := decodeToken(token); ok != tt.ok {
+				t.Errorf("valid %v, want %v", ok, tt.ok)
+			}
+
+			var n int64
+			db.Model(&Hmac{}).Count(&n)
+			if n > hmacKeep+1 {
+				t.Errorf("%d keys kept, want at most %d", n, hmacKeep+1)
+			}
+		})
+	}
+}
+
+// 其他进程轮换的新密钥在遇到未知 kid 时读取
+func TestLookupHmacKeys_reload(t *testing.T) {
+	withTestDb(t, &User{}, &Session{}, &Hmac{})
+	withHmac(t)
+
+	old, _ := currentHmacKey()
+	err := db.Model(&Hmac{}).Where("id = ?", old).Update("retired_at", time.Now()).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Hmac{Key: "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}
+	err = db.Create(&h).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		loadedAt time.Duration // 距上次读取的时长
+		kid      int
+		want     int
+	}{
+		{"throttled", 0, h.Id, 0},
+		{"reloaded", hmacReloadInterval, h.Id, 1},
+		{"unknown", hmacReloadInterval, h.Id + 1, 0},
+		{"all", 0, 0, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hmacMu.Lock()
+			hmacLoadedAt = time.Now().Add(-tt.loadedAt)
+			hmacMu.Unlock()
+
+			if got := len(lookupHmacKeys(tt.kid)); got != tt.want {
+				t.Errorf("%d keys, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if kid, _ := currentHmacKey(); kid != h.Id {
+		t.Errorf("signing with kid %d, want %d", kid, h.Id)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 单个 sitemap 文件的 url 上限
+const sitemapSize = 50000
+
+const defaultRobots = "User-agent: *\nAllow: /\n"
+
+var robotsTxt = defaultRobots
+
+type sitemapUrl struct {
+	Path    string
+	Lastmod time.Time
+}
+
+type (
+	urlset struct {
+		XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+		Urls    []sitemapLoc `xml:"url"`
+	}
+
+	sitemapindex struct {
+		XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+		Sitemaps []sitemapLoc `xml:"sitemap"`
+	}
+
+	sitemapLoc struct {
+		Loc     string `xml:"loc"`
+		Lastmod string `xml:"lastmod,omitempty"`
+	}
+)
+
+// cfg.robots 未指定时读取 rootfs/robots.txt，均不存在则使用默认内容
+func initializeRobots(cfg *config) {
+	path := cfg.robots
+	if path == "" {
+		path = filepath.Join(cfg.rootfs, "robots.txt")
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && cfg.robots == "" {
+			return
+		}
+		log.Fatalln("error:", err)
+	}
+
+	robotsTxt = string(b)
+}
+
+// Sitemap 行含绝对地址，缓存规则与 sitemap.xml 相同
+func robots(c *gin.Context) {
+	txt := robotsTxt
+	if !strings.Contains(strings.ToLower(txt), "sitemap:") {
+		txt = strings.TrimRight(txt, "\n") + "\nSitemap: " + baseUrl(c) + "/sitemap.xml\n"
+	}
+
+	cacheAbsolute(c, 2592000)
+	c.Data(200, "text/plain; charset=utf-8", []byte(txt))
+}
+
+func sitemap(f *gin.RouterGroup) {
+	f.GET("/sitemap.xml", func(c *gin.Context) {
+		renderSitemap(c, 0)
+	})
+	f.GET("/sitemap/:page", func(c *gin.Context) {
+		page, err := strconv.Atoi(strings.TrimSuffix(c.Param("page"), ".xml"))
+		if err != nil || page <= 0 {
+			c.Status(404)
+			return
+		}
+		renderSitemap(c, page)
+	})
+}
+
+// page 为 0 时，url 数量未超过上限输出 urlset，否则输出 sitemapindex
+func renderSitemap(c *gin.Context, page int) {
+	var urls []sitemapUrl
+	err := querySitemap(&urls)
+	if err != nil {
+		log.Println("error:", err)
+		c.Status(500)
+		return
+	}
+
+	pages := (len(urls) + sitemapSize - 1) / sitemapSize
+	if page > pages || (page > 0 && pages == 1) {
+		c.Status(404)
+		return
+	}
+
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	cacheAbsolute(c, 3600)
+
+	base := baseUrl(c)
+	if page == 0 && pages > 1 {
+		err = writeSitemapIndex(c.Writer, base, urls)
+	} else {
+		if page > 0 {
+			urls = urls[(page-1)*sitemapSize : min(page*sitemapSize, len(urls))]
+		}
+		err = writeSitemap(c.Writer, base, urls)
+	}
+	if err != nil {
+		log.Println("error:", err)
+	}
+}
+
+// 仅包含公开版块及其主题，lastmod 取最新楼层的更新时间
+func querySitemap(dest *[]sitemapUrl) error {
+	var rows []struct {
+		Id      int
+		Lastmod string
+	}
+
+	err := db.Raw(fmt.Sprintf(`SELECT m.id, MAX(p.updated_at) AS lastmod FROM modes AS m
+		LEFT JOIN topics AS t ON t.mode_id = m.id AND t.deleted_at IS NULL AND %s
+		LEFT JOIN posts AS p ON p.topic_id = t.id AND p.deleted_at IS NULL AND p.status = 'approved'
+		WHERE m.pub = true AND m.deleted_at IS NULL GROUP BY m.id ORDER BY m.id`, publishedCond("t"))).Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	var last time.Time
+	*dest = append(*dest, sitemapUrl{Path: "/"}, sitemapUrl{Path: "/cv"})
+	for _, r := range rows {
+		t := parseSqliteTime(r.Lastmod)
+		if t.After(last) {
+			last = t
+		}
+		*dest = append(*dest, sitemapUrl{Path: "/cv/" + strconv.Itoa(r.Id), Lastmod: t})
+	}
+	(*dest)[0].Lastmod = last
+	(*dest)[1].Lastmod = last
+
+	rows = nil
+	err = db.Raw(fmt.Sprintf(`SELECT t.id, MAX(p.updated_at) AS lastmod FROM topics AS t
+		LEFT JOIN posts AS p ON p.topic_id = t.id AND p.deleted_at IS NULL AND p.status = 'approved'
+		WHERE t.deleted_at IS NULL %s
+		GROUP BY t.id ORDER BY t.id DESC`, publicFilter)).Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rows {
+		*dest = append(*dest, sitemapUrl{Path: "/av/" + strconv.Itoa(r.Id), Lastmod: parseSqliteTime(r.Lastmod)})
+	}
+
+	return nil
+}
+
+// 聚合函数返回的时间为 go-sqlite3 写入的文本格式
+func parseSqliteTime(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04:05.999999999-07:00", s)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+func writeSitemap(w io.Writer, base string, urls []sitemapUrl) error {
+	doc := urlset{
+		Urls: make([]sitemapLoc, 0, len(urls)),
+	}
+	for _, u := range urls {
+		doc.Urls = append(doc.Urls, sitemapLoc{
+			Loc:     base + u.Path,
+			Lastmod: w3cDate(u.Lastmod),
+		})
+	}
+
+	return writeXml(w, doc)
+}
+
+func writeSitemapIndex(w io.Writer, base string, urls []sitemapUrl) error {
+	var doc sitemapindex
+	for i := 0; i < len(urls); i += sitemapSize {
+		var last time.Time
+		for _, u := range urls[i:min(i+sitemapSize, len(urls))] {
+			if u.Lastmod.After(last) {
+				last = u.Lastmod
+			}
+		}
+		doc.Sitemaps = append(doc.Sitemaps, sitemapLoc{
+			Loc:     fmt.Sprintf("%s/sitemap/%d.xml", base, i/sitemapSize+1),
+			Lastmod: w3cDate(last),
+		})
+	}
+
+	return writeXml(w, doc)
+}
+
+func w3cDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseSqliteTime(t *testing.T) {
+	tests := []struct {
+		src  string
+		want time.Time
+	}{
+		{"2024-01-02 03:04:05.123456789+08:00", time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.FixedZone("", 8*3600))},
+		{"2024-01-02 03:04:05+00:00", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"", time.Time{}},
+		{"garbage", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			if got := parseSqliteTime(tt.src); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 超过上限时拆分为多个 sitemap，索引的 lastmod 取各自最新的时间
+func TestWriteSitemapIndex(t *testing.T) {
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	last := old.Add(time.Hour)
+	urls := make([]sitemapUrl, sitemapSize+1)
+	urls[1].Lastmod = old
+	urls[sitemapSize].Lastmod = last
+
+	var buf bytes.Buffer
+	err := writeSitemapIndex(&buf, "https://blog.example", urls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc sitemapindex
+	err = xml.Unmarshal(buf.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sitemapLoc{
+		{Loc: "https://blog.example/sitemap/1.xml", Lastmod: "2024-01-01T00:00:00Z"},
+		{Loc: "https://blog.example/sitemap/2.xml", Lastmod: "2024-01-01T01:00:00Z"},
+	}
+	if len(doc.Sitemaps) != len(want) {
+		t.Fatalf("got %+v", doc.Sitemaps)
+	}
+	for i := range want {
+		if doc.Sitemaps[i] != want[i] {
+			t.Errorf("sitemap %d: got %+v, want %+v", i, doc.Sitemaps[i], want[i])
+		}
+	}
+}
+
+func TestInitializeRobots(t *testing.T) {
+	t.Cleanup(func() {
+		robotsTxt = defaultRobots
+	})
+	rootfs := t.TempDir()
+	custom := filepath.Join(t.TempDir(), "robots.txt")
+	err := os.WriteFile(custom, []byte("User-agent: *\nDisallow: /\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		robots string
+		local  string // rootfs/robots.txt 的内容，为空时不存在
+		want   string
+	}{
+		{"default", "", "", defaultRobots},
+		{"data dir", "", "User-agent: bot\n", "User-agent: bot\n"},
+		{"configured", custom, "User-agent: bot\n", "User-agent: *\nDisallow: /\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			robotsTxt = defaultRobots
+			os.Remove(filepath.Join(rootfs, "robots.txt"))
+			if tt.local != "" {
+				err := os.WriteFile(filepath.Join(rootfs, "robots.txt"), []byte(tt.local), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			initializeRobots(&config{rootfs: rootfs, robots: tt.robots})
+			if robotsTxt != tt.want {
+				t.Errorf("got %q, want %q", robotsTxt, tt.want)
+			}
+		})
+	}
+}
+
+// sitemap 只含公开版块的主题，robots.txt 未声明时追加 Sitemap 行
+func TestSitemap(t *testing.T) {
+	savedSite := site
+	t.Cleanup(func() {
+		site = savedSite
+		robotsTxt = defaultRobots
+	})
+	site.Url = ""
+
+	pub := newTestTopic(t, true)
+	private := newTestTopic(t, false)
+
+	r := gin.New()
+	r.GET("/robots.txt", robots)
+	sitemap(r.Group("/"))
+
+	tests := []struct {
+		name    string
+		robots  string
+		path    string
+		code    int
+		want    []string
+		exclude []string
+	}{
+		{"robots", defaultRobots, "/robots.txt", 200, []string{"Sitemap: http://example.com/sitemap.xml"}, nil},
+		{"robots with sitemap", "Sitemap: https://cdn.example/s.xml\n", "/robots.txt", 200,
+			[]string{"Sitemap: https://cdn.example/s.xml"}, []string{"example.com/sitemap.xml"}},
+		{"sitemap", defaultRobots, "/sitemap.xml", 200,
+			[]string{"http://example.com/av/" + strconv.Itoa(pub.Id) + "<", "http://example.com/cv/" + strconv.Itoa(pub.ModeId) + "<"},
+			[]string{"/av/" + strconv.Itoa(private.Id) + "<", "/cv/" + strconv.Itoa(private.ModeId) + "<"}},
+		{"single page", defaultRobots, "/sitemap/2.xml", 404, nil, nil},
+		{"bad page", defaultRobots, "/sitemap/x.xml", 404, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			robotsTxt = tt.robots
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d", w.Code, tt.code)
+			}
+			if tt.code == 200 && w.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Cache-Control %q", w.Header().Get("Cache-Control"))
+			}
+
+			body := w.Body.String()
+			for _, s := range tt.want {
+				if !strings.Contains(body, s) {
+					t.Errorf("missing %q", s)
+				}
+			}
+			for _, s := range tt.exclude {
+				if strings.Contains(body, s) {
+					t.Errorf("contains %q", s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	maxGuestLinks   = 2               // 单条回复允许的链接数
+	minFillTime     = 3 * time.Second // 取得表单 token 到提交的最短间隔
+	maxFillTime     = 24 * time.Hour  // 表单 token 有效期
+	guestRateLimit  = 5               // 每个 IP 在 guestRateWindow 内的提交次数
+	guestRateWindow = 10 * time.Minute
+)
+
+// Spam 被拦截的游客回复，审核后可放入待审核队列
+type Spam struct {
+	Id        int       `gorm:"primaryKey"     json:"id"`
+	TopicId   int       `gorm:"not null"       json:"topic_id"`
+	Author    string    `gorm:"not null"       json:"author"`
+	Content   string    `gorm:"not null"       json:"content"`
+	Ip        string    `gorm:"not null"       json:"ip"`
+	Reason    string    `gorm:"not null"       json:"reason"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// submission 待检查的游客提交
+type submission struct {
+	TopicId  int
+	Author   string
+	Content  string
+	Ip       string
+	Honeypot string
+	Token    string
+	Now      time.Time
+}
+
+// spamChecker 返回非空字符串表示拦截原因
+type spamChecker interface {
+	check(s *submission) string
+}
+
+type (
+	linkChecker     struct{ max int }
+	wordChecker     struct{ words []string }
+	honeypotChecker struct{}
+	fillTimeChecker struct{ min, max time.Duration }
+)
+
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)`)
+
+func (l linkChecker) check(s *submission) string {
+	if len(linkPattern.FindAllStringIndex(s.Author+" "+s.Content, -1)) > l.max {
+		return "too many links"
+	}
+	return ""
+}
+
+func (w wordChecker) check(s *submission) string {
+	text := strings.ToLower(s.Author + " " + s.Content)
+	for _, word := range w.words {
+		if strings.Contains(text, word) {
+			return "banned word: " + word
+		}
+	}
+	return ""
+}
+
+func (honeypotChecker) check(s *submission) string {
+	if s.Honeypot != "" {
+		return "honeypot"
+	}
+	return ""
+}
+
+// 通过检查的 token 随即作废，同一个 token 不能重复提交
+func (f fillTimeChecker) check(s *submission) string {
+	issued, nonce, err := verifyFormToken(s.Token, s.TopicId)
+	if err != nil {
+		return "form token: " + err.Error()
+	}
+
+	elapsed := s.Now.Sub(issued)
+	if elapsed < f.min {
+		return "filled too fast"
+	}
+	if elapsed > f.max {
+		return "form token: expired"
+	}
+	if !usedForms.use(nonce, issued.Add(f.max), s.Now) {
+		return "form token: reused"
+	}
+	return ""
+}
+
+var (
+	spamCheckers []spamChecker
+	guestLimiter = newRateLimiter(guestRateLimit, guestRateWindow)
+	usedForms    = newNonceSet()
+)
+
+// 依次运行检查器，返回第一个拦截原因
+func checkSpam(s *submission) string {
+	for _, c := range spamCheckers {
+		if reason := c.check(s); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// 屏蔽词从 rootfs/banned_words.txt 读取，每行一个，# 开头为注释
+func initializeSpam(cfg *config) {
+	words, err := readWordList(filepath.Join(cfg.rootfs, "banned_words.txt"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalln("error:", err)
+	}
+
+	spamCheckers = []spamChecker{
+		honeypotChecker{},
+		fillTimeChecker{min: minFillTime, max: maxFillTime},
+		linkChecker{max: maxGuestLinks},
+		wordChecker{words: words},
+	}
+}
+
+func readWordList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		w := strings.ToLower(strings.TrimSpace(sc.Text()))
+		if w == "" || strings.HasPrefix(w, "#") {
+			continue
+		}
+		words = append(words, w)
+	}
+
+	return words, sc.Err()
+}
+
+// 拦截的回复放入待审核队列，创建与删除在同一事务中，失败时不会留下重复的回复
+func releaseSpam(ids []int) ([]Post, error) {
+	var posts []Post
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var items []Spam
+		err := tx.Where("id IN ?", ids).Order("id").Find(&items).Error
+		if err != nil {
+			return err
+		}
+
+		for _, s := range items {
+			var topic Topic
+			err = tx.Model(&topic).Where("id = ?", s.TopicId).Select("id").Take(&topic).Error
+			if err != nil {
+				return err
+			}
+
+			p := Post{
+				TopicId:     s.TopicId,
+				Author:      s.Author,
+				Ip:          s.Ip,
+				Status:      postPending,
+				Content:     s.Content,
+				ContentHtml: renderMarkdown(s.Content),
+			}
+			err = tx.Create(&p).Error
+			if err != nil {
+				return err
+			}
+
+			err = tx.Delete(&s).Error
+			if err != nil {
+				return err
+			}
+			posts = append(posts, p)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+// 表单 token 记录签发时间与一次性随机数，与主题绑定
+func encodeFormToken(tid int, now time.Time) (string, error) {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	ts := strconv.FormatInt(now.Unix(), 10)
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	return ts + "." + nonce + "." + signForm(tid, ts, nonce), nil
+}
+
+func verifyFormToken(token string, tid int) (time.Time, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, "", errors.New("malformed")
+	}
+	ts, nonce, sig := parts[0], parts[1], parts[2]
+	if !verifyFormSign(tid, ts, nonce, sig) {
+		return time.Time{}, "", errors.New("bad signature")
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, "", errors.New("malformed")
+	}
+
+	return time.Unix(sec, 0), nonce, nil
+}
+
+func signForm(tid int, ts string, nonce string) string {
+	_, key := currentHmacKey()
+	return formMac(key, tid, ts, nonce)
+}
+
+// 密钥轮换前签发的表单仍可通过验证
+func verifyFormSign(tid int, ts string, nonce string, sig string) bool {
+	for _, key := range lookupHmacKeys(0) {
+		if hmac.Equal([]byte(sig), []byte(formMac(key, tid, ts, nonce))) {
+			return true
+		}
+	}
+	return false
+}
+
+func formMac(key []byte, tid int, ts string, nonce string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("guest:" + strconv.Itoa(tid) + ":" + ts + ":" + nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// nonceSet 已使用的表单随机数，记录到 token 过期为止，重启后清空
+type nonceSet struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newNonceSet() *nonceSet {
+	return &nonceSet{
+		seen: make(map[string]time.Time),
+	}
+}
+
+// 首次使用返回 true，expires 之后可从集合中移除
+func (n *nonceSet) use(nonce string, expires time.Time, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if len(n.seen) > 10000 {
+		for k, v := range n.seen {
+			if now.After(v) {
+				delete(n.seen, k)
+			}
+		}
+	}
+
+	if _, ok := n.seen[nonce]; ok {
+		return false
+	}
+	n.seen[nonce] = expires
+	return true
+}
+
+// rateLimiter 滑动窗口计数，按 key 限制请求次数
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string][]time.Time),
+	}
+}
+
+// 记录一次请求，超出限制时返回 false 及需等待的时长
+func (r *rateLimiter) allow(key string, now time.Time) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.hits) > 10000 {
+		for k, v := range r.hits {
+			if now.Sub(v[len(v)-1]) >= r.window {
+				delete(r.hits, k)
+			}
+		}
+	}
+
+	hits := r.hits[key]
+	for len(hits) > 0 && now.Sub(hits[0]) >= r.window {
+		hits = hits[1:]
+	}
+
+	if len(hits) >= r.limit {
+		r.hits[key] = hits
+		return r.window - now.Sub(hits[0]), false
+	}
+
+	r.hits[key] = append(hits, now)
+	return 0, true
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpamCheckers(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		checker spamChecker
+		sub     submission
+		want    string
+	}{
+		{"links ok", linkChecker{max: 2}, submission{Content: "see http://a.com and www.b.com"}, ""},
+		{"too many links", linkChecker{max: 2}, submission{Author: "http://x", Content: "http://a https://b"}, "too many links"},
+		{"word", wordChecker{words: []string{"casino"}}, submission{Content: "Best CASINO here"}, "banned word: casino"},
+		{"word in author", wordChecker{words: []string{"casino"}}, submission{Author: "casino bot"}, "banned word: casino"},
+		{"clean", wordChecker{words: []string{"casino"}}, submission{Content: "hello"}, ""},
+		{"honeypot", honeypotChecker{}, submission{Honeypot: "x"}, "honeypot"},
+		{"no honeypot", honeypotChecker{}, submission{}, ""},
+		{"bad token", fillTimeChecker{min: time.Second, max: time.Hour}, submission{Token: "a.b", Now: now}, "form token: malformed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.checker.check(&tt.sub); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFillTimeChecker(t *testing.T) {
+	err := db.AutoMigrate(&Hmac{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	initializeHmac()
+
+	issued := time.Now().Truncate(time.Second)
+	checker := fillTimeChecker{min: minFillTime, max: maxFillTime}
+	tests := []struct {
+		name  string
+		tid   int // 提交时的主题，与签发时不同则签名无效
+		after time.Duration
+		want  string
+	}{
+		{"too fast", 1, time.Second, "filled too fast"},
+		{"expired", 1, maxFillTime + time.Second, "form token: expired"},
+		{"other topic", 2, time.Minute, "form token: bad signature"},
+		{"ok", 1, time.Minute, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := encodeFormToken(1, issued)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := submission{TopicId: tt.tid, Token: token, Now: issued.Add(tt.after)}
+			if got := checker.check(&s); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if tt.want == "" {
+				if got := checker.check(&s); got != "form token: reused" {
+					t.Errorf("second use: got %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		hits  []time.Duration // 相对 start 的请求时间
+		ok    bool            // 最后一次请求是否放行
+		waits time.Duration
+	}{
+		{"under limit", []time.Duration{0, time.Second}, true, 0},
+		{"at limit", []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second}, false, 57 * time.Second},
+		{"window slides", []time.Duration{0, time.Second, 2 * time.Second, time.Minute}, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRateLimiter(3, time.Minute)
+			var wait time.Duration
+			var ok bool
+			for _, h := range tt.hits {
+				wait, ok = r.allow("1.2.3.4", start.Add(h))
+			}
+			if ok != tt.ok || wait != tt.waits {
+				t.Errorf("got (%v, %v), want (%v, %v)", wait, ok, tt.waits, tt.ok)
+			}
+			if _, ok := r.allow("5.6.7.8", start); !ok {
+				t.Error("other key limited")
+			}
+		})
+	}
+}
+
+// 放行的回复进入待审核队列，任一条失败时整体回滚
+func TestReleaseSpam(t *testing.T) {
+	err := db.AutoMigrate(&Spam{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic := newTestTopic(t, true)
+
+	tests := []struct {
+		name    string
+		topics  []int
+		wantErr bool
+	}{
+		{"release", []int{topic.Id, topic.Id}, false},
+		{"missing topic", []int{topic.Id, -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []int
+			for _, tid := range tt.topics {
+				s := Spam{TopicId: tid, Author: "guest", Content: tt.name, Reason: "test"}
+				if err := db.Create(&s).Error; err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, s.Id)
+			}
+
+			posts, err := releaseSpam(ids)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var spam, pending int64
+			db.Model(&Spam{}).Where("id IN ?", ids).Count(&spam)
+			db.Model(&Post{}).Where("topic_id = ? AND content = ?", topic.Id, tt.name).
+				Where("status = ?", postPending).Count(&pending)
+			if tt.wantErr {
+				if spam != int64(len(ids)) || pending != 0 {
+					t.Errorf("not rolled back: %d spam left, %d pending posts", spam, pending)
+				}
+				return
+			}
+			if spam != 0 || pending != int64(len(ids)) || len(posts) != len(ids) {
+				t.Errorf("%d spam left, %d pending posts, %d returned", spam, pending, len(posts))
+			}
+		})
+	}
+}
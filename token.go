@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 访问令牌前缀，用于与 JWT 区分
+const apiTokenPrefix = "slp_"
+
+// 访问令牌权限范围
+const (
+	scopeReadPrivate = "read:private" // 读取非公开内容
+	scopePostWrite   = "post:write"   // 发布与编辑主题、楼层
+	scopeModeAdmin   = "mode:admin"   // 管理版块、回收站与审核
+)
+
+// 各权限范围需要的最低角色
+var scopeRole = map[string]string{
+	scopeReadPrivate: roleReader,
+	scopePostWrite:   roleEditor,
+	scopeModeAdmin:   roleEditor,
+}
+
+// ApiToken 个人访问令牌，仅保存哈希
+type ApiToken struct {
+	Id         int        `gorm:"primaryKey"           json:"id"`
+	UserId     int        `gorm:"index;not null"       json:"user_id"`
+	Name       string     `gorm:"not null"             json:"name"`
+	Prefix     string     `gorm:"not null"             json:"prefix"` // 令牌开头几位，便于辨认
+	Hash       string     `gorm:"uniqueIndex;not null" json:"-"`
+	Scopes     string     `gorm:"not null"             json:"scopes"` // 以空格分隔
+	CreatedAt  time.Time  `gorm:"autoCreateTime"       json:"created_at"`
+	ExpiresAt  *time.Time `gorm:"index"                json:"expires_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at"  json:"last_used_at"`
+	LastUsedIp string     `gorm:"not null;default:''"  json:"last_used_ip"`
+}
+
+func hashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 创建访问令牌，返回仅此一次可见的明文
+func createApiToken(t *ApiToken, role string, scopes []string) (string, error) {
+	seen := make(map[string]bool)
+	var list []string
+	for _, s := range scopes {
+		need, ok := scopeRole[s]
+		if !ok {
+			return "", errors.New("unknown scope: " + s)
+		}
+		if roleLevel[role] < roleLevel[need] {
+			return "", errors.New("scope not allowed: " + s)
+		}
+		if !seen[s] {
+			seen[s] = true
+			list = append(list, s)
+		}
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	t.Prefix = token[:len(apiTokenPrefix)+6]
+	t.Hash = hashApiToken(token)
+	t.Scopes = strings.Join(list, " ")
+
+	return token, db.Create(t).Error
+}
+
+// 校验访问令牌并记录使用时间，返回用户 id 与权限范围
+func verifyApiToken(token string, ip string) (int, []string, bool) {
+	now := time.Now()
+
+	var t ApiToken
+	err := db.Where("hash = ?", hashApiToken(token)).
+		Where("expires_at IS NULL OR expires_at > ?", now).Take(&t).Error
+	if err != nil {
+		return 0, nil, false
+	}
+
+	err = db.Model(&t).UpdateColumns(map[string]interface{}{
+		"last_used_at": now,
+		"last_used_ip": ip,
+	}).Error
+	if err != nil {
+		return 0, nil, false
+	}
+
+	return t.UserId, strings.Fields(t.Scopes), true
+}
+
+// 访问令牌是否具备 scopes 之一，登录会话不受限制
+func hasScope(c *gin.Context, scopes ...string) bool {
+	v, ok := c.Get("scopes")
+	if !ok {
+		return true
+	}
+
+	for _, granted := range v.([]string) {
+		for _, s := range scopes {
+			if granted == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 判断可见范围使用的用户 id，未授予 read:private 的访问令牌视为匿名
+func viewerUid(c *gin.Context) int {
+	if !hasScope(c, scopeReadPrivate) {
+		return -1
+	}
+	return c.MustGet("uid").(int)
+}
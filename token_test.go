@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		granted []string // nil 表示登录会话
+		scopes  []string
+		want    bool
+	}{
+		{"session", nil, []string{scopeModeAdmin}, true},
+		{"granted", []string{scopePostWrite}, []string{scopePostWrite}, true},
+		{"any of", []string{scopeReadPrivate}, []string{scopePostWrite, scopeReadPrivate}, true},
+		{"missing", []string{scopeReadPrivate}, []string{scopePostWrite}, false},
+		{"no scopes", []string{}, []string{scopeReadPrivate}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			if tt.granted != nil {
+				c.Set("scopes", tt.granted)
+			}
+			if got := hasScope(c, tt.scopes...); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyApiToken(t *testing.T) {
+	err := db.AutoMigrate(&ApiToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name      string
+		role      string
+		scopes    []string
+		expiresAt *time.Time
+		wantErr   bool
+		ok        bool
+		granted   []string // 重复的权限范围只保留一次
+	}{
+		{"reader", roleReader, []string{scopeReadPrivate}, nil, false, true, []string{scopeReadPrivate}},
+		{"editor", roleEditor, []string{scopePostWrite, scopeModeAdmin, scopePostWrite}, &future, false, true, []string{scopePostWrite, scopeModeAdmin}},
+		{"expired", roleEditor, []string{scopePostWrite}, &past, false, false, nil},
+		{"scope above role", roleReader, []string{scopePostWrite}, nil, true, false, nil},
+		{"unknown scope", roleOwner, []string{"admin"}, nil, true, false, nil},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid := 1000 + i
+			db.Where("user_id = ?", uid).Delete(&ApiToken{})
+			token, err := createApiToken(&ApiToken{UserId: uid, Name: tt.name, ExpiresAt: tt.expiresAt}, tt.role, tt.scopes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("create: err %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got, scopes, ok := verifyApiToken(token, "127.0.0.1")
+			if ok != tt.ok {
+				t.Fatalf("ok %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+
+			if got != uid || !reflect.DeepEqual(scopes, tt.granted) {
+				t.Errorf("got (%d, %v), want (%d, %v)", got, scopes, uid, tt.granted)
+			}
+
+			var stored ApiToken
+			err = db.Where("user_id = ?", uid).Take(&stored).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.LastUsedAt == nil || stored.LastUsedIp != "127.0.0.1" {
+				t.Errorf("last used not recorded: %v %q", stored.LastUsedAt, stored.LastUsedIp)
+			}
+		})
+	}
+
+	_, _, ok := verifyApiToken(apiTokenPrefix+"invalid", "127.0.0.1")
+	if ok {
+		t.Error("invalid token accepted")
+	}
+}
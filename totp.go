@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RFC 6238 参数，与常见验证器应用默认值一致
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // 允许前后偏差的时间步数
+)
+
+// 恢复码数量
+const recoveryCount = 10
+
+// RecoveryCode 两步验证恢复码，仅保存哈希，使用后删除
+type RecoveryCode struct {
+	Id     int    `gorm:"primaryKey"`
+	UserId int    `gorm:"index;not null"`
+	Hash   string `gorm:"not null"`
+}
+
+var (
+	errTotpRequired = errors.New("totp required")
+	errTotpInvalid  = errors.New("totp invalid")
+)
+
+// 加密 totp 密钥的 AES 密钥，保存在 rootfs/totp.key
+var totpCipher cipher.AEAD
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func initializeTotp(cfg *config) {
+	path := filepath.Join(cfg.rootfs, "totp.key")
+
+	key, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		key = generateHmacKey()
+		err = os.WriteFile(path, key, 0o600)
+	}
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	if len(key) != 32 {
+		log.Fatalln("error:", path+" must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	totpCipher, err = cipher.NewGCM(block)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+}
+
+func encryptSecret(secret []byte) (string, error) {
+	nonce := make([]byte, totpCipher.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(totpCipher.Seal(nonce, nonce, secret, nil)), nil
+}
+
+func decryptSecret(str string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < totpCipher.NonceSize() {
+		return nil, errors.New("malformed secret")
+	}
+
+	n := totpCipher.NonceSize()
+	return totpCipher.Open(nil, b[:n], b[n:], nil)
+}
+
+// 计算第 counter 个时间步的验证码
+func totpCode(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, v%mod)
+}
+
+// 校验验证码，返回匹配的时间步，last 之前的时间步视为重放
+func verifyTotp(secret []byte, code string, now time.Time, last int64) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	cur := now.Unix() / totpPeriod
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		counter := cur + i
+		if counter <= last {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(secret, counter)), []byte(code)) {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
+
+// 验证器应用使用的 otpauth 链接
+func totpUri(username string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", b32.EncodeToString(secret))
+	v.Set("issuer", site.Title)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(site.Title + ":" + username)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// 生成新的 totp 密钥，启用前需先验证一次
+func setupTotp(uid int) (string, string, error) {
+	var user User
+	err := db.Where("id = ?", uid).Take(&user).Error
+	if err != nil {
+		return "", "", err
+	}
+	if user.TotpEnabled {
+		return "", "", errors.New("totp already enabled")
+	}
+
+	secret := make([]byte, 20)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	enc, err := encryptSecret(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = db.Model(&user).Updates(map[string]interface{}{
+		"totp_secret": enc,
+		"totp_last":   0,
+	}).Error
+	if err != nil {
+		return "", "", err
+	}
+
+	return b32.EncodeToString(secret), totpUri(user.Username, secret), nil
+}
+
+// 验证码正确时启用两步验证，返回恢复码
+func enableTotp(uid int, code string) ([]string, error) {
+	var user User
+	err := db.Where("id = ?", uid).Take(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	if user.TotpEnabled {
+		return nil, errors.New("totp already enabled")
+	}
+	if user.TotpSecret == "" {
+		return nil, errors.New("totp not set up")
+	}
+
+	secret, err := decryptSecret(user.TotpSecret)
+	if err != nil {
+		return nil, err
+	}
+	counter, ok := verifyTotp(secret, code, time.Now(), 0)
+	if !ok {
+		return nil, errTotpInvalid
+	}
+
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled": true,
+			"totp_last":    counter,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		codes, err = newRecoveryCodes(tx, uid)
+		return err
+	})
+
+	return codes, err
+}
+
+// 关闭两步验证并删除密钥与恢复码
+func disableTotp(tx *gorm.DB, uid int) error {
+	err := tx.Model(&User{}).Where("id = ?", uid).Updates(map[string]interface{}{
+		"totp_enabled": false,
+		"totp_secret":  "",
+		"totp_last":    0,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Where("user_id = ?", uid).Delete(&RecoveryCode{}).Error
+}
+
+// 按用户名关闭两步验证，用于设备丢失
+func disableTotpByName(username string) error {
+	var user User
+	err := db.Where("username = ?", username).Select("id").Take(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.New("user not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		return disableTotp(tx, user.Id)
+	})
+}
+
+// 替换用户的全部恢复码
+func newRecoveryCodes(tx *gorm.DB, uid int) ([]string, error) {
+	err := tx.Where("user_id = ?", uid).Delete(&RecoveryCode{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err = rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		s := hex.EncodeToString(b)
+		codes[i] = s[:5] + "-" + s[5:]
+
+		err = tx.Create(&RecoveryCode{UserId: uid, Hash: hashRecoveryCode(codes[i])}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// 登录第二步，code 可以是验证码或恢复码，恢复码使用后失效
+func checkSecondFactor(user *User, code string) error {
+	if !user.TotpEnabled {
+		return nil
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return errTotpRequired
+	}
+
+	if len(code) == totpDigits {
+		secret, err := decryptSecret(user.TotpSecret)
+		if err != nil {
+			return err
+		}
+
+		counter, ok := verifyTotp(secret, code, time.Now(), user.TotpLast)
+		if !ok {
+			return errTotpInvalid
+		}
+
+		tx := db.Model(&User{}).Where("id = ?", user.Id).Where("totp_last < ?", counter).
+			Update("totp_last", counter)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		if tx.RowsAffected == 0 {
+			return errTotpInvalid
+		}
+		return nil
+	}
+
+	tx := db.Where("user_id = ?", user.Id).Where("hash = ?", hashRecoveryCode(code)).Delete(&RecoveryCode{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return errTotpInvalid
+	}
+
+	return nil
+}
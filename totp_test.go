@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试向量，取 8 位验证码的后 6 位
+func TestTotpCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got := totpCode(secret, tt.unix/totpPeriod)
+		if got != tt.want {
+			t.Errorf("T = %d: got %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
@@ -2,18 +2,44 @@ package main
 
 import (
 	"embed"
-	"fmt"
+	"errors"
+	"html/template"
 	"io/fs"
 	"log"
+	"mime"
 	"net/http"
+	"os"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
 )
 
 //go:embed all:dist
 var web embed.FS
 
+// 站点信息，可由配置文件覆盖
+var site = siteMeta{
+	Title: "sealog",
+}
+
+// 分页步长，查询时多取一行并以 Id = -1 占位表示还有下一页
+var pageSize = 20
+
+type htmlPage struct {
+	Title   string
+	Data    any
+	Prev    int
+	Next    int
+	HasPrev bool
+	HasNext bool
+	PrevUrl string // 服务端为 ?offset=，静态站点为 page/n/
+	NextUrl string
+}
+
+var pages map[string]*template.Template
+
 func cacheMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Cache-Control", "public, max-age=2592000")
@@ -21,9 +47,7 @@ func cacheMiddleware() gin.HandlerFunc {
 }
 
 func static(s *gin.RouterGroup) {
-	s.GET("/robots.txt", func(c *gin.Context) {
-		c.Data(200, "text/plain", []byte("User-agent: *\nAllow: /"))
-	})
+	s.GET("/robots.txt", robots)
 
 	s.GET("/favicon.ico", func(c *gin.Context) {
 		c.Data(200, "image/x-icon", []byte{})
@@ -35,19 +59,243 @@ func static(s *gin.RouterGroup) {
 	}
 
 	s.StaticFS("/assets/", http.FS(assets))
+
+	s.GET("/file/:hash", serveFile)
+}
+
+// /file/:hash
+func serveFile(c *gin.Context) {
+	f, err := getFile(c.Param("hash"))
+	if err != nil {
+		c.Status(404)
+		return
+	}
+
+	file, err := os.Open(filePath(f.Hash))
+	if err != nil {
+		log.Println("error:", err)
+		c.Status(404)
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Type", f.Mime)
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("ETag", `"`+f.Hash+`"`)
+	c.Header("X-Content-Type-Options", "nosniff")
+	if !inlineMime(f.Mime) {
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": f.Name}))
+	}
+
+	http.ServeContent(c.Writer, c.Request, f.Name, f.CreatedAt, file)
 }
 
-// todo 暂定 SPA，url 页面组装完整 html 返回，其余页面由 js 替换页面组件实现
+func initializeTemplate() {
+	funcs := template.FuncMap{
+		"date": func(t time.Time) string {
+			return t.Local().Format("2006-01-02 15:04")
+		},
+		"iso": func(t time.Time) string {
+			return t.Format(time.RFC3339)
+		},
+		"site": func() string {
+			return site.Title
+		},
+		"description": func() string {
+			return site.Description
+		},
+		// 仅用于 renderMarkdown 的输出
+		"safe": func(s string) template.HTML {
+			return template.HTML(s)
+		},
+	}
+
+	pages = make(map[string]*template.Template)
+	for _, name := range []string{"topics", "modes", "topic", "mode", "tags", "tag", "error"} {
+		t, err := template.New(name).Funcs(funcs).
+			ParseFS(web, "dist/template/layout.html", "dist/template/"+name+".html")
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		pages[name] = t
+	}
+}
+
+// url 页面组装完整 html 返回，其余页面由 js 替换页面组件实现
 func renderHtml(h *gin.RouterGroup) {
-	h.GET("/", func(c *gin.Context) {})
-	h.GET("/av", func(c *gin.Context) {})
-	h.GET("/cv", func(c *gin.Context) {})
-	h.GET("/av/:aid", func(c *gin.Context) {})
-	h.GET("/cv/:cid", func(c *gin.Context) {
-		uid := c.MustGet("uid").(int)
-		cid, _ := strconv.Atoi(c.Param("cid"))
-		var cv resCid
-		_, _, _ = queryTopicsByMode(&cv, uid, cid, 0)
-		fmt.Println(cv)
+	initializeTemplate()
+
+	h.GET("/", getTopicsPage)
+	h.GET("/av", getTopicsPage)
+	h.GET("/cv", getModesPage)
+	h.GET("/av/:aid", getTopicAndPostsPage)
+	h.GET("/cv/:cid", getTopicsByModePage)
+	h.GET("/tag", getTagsPage)
+	h.GET("/tag/:name", getTopicsByTagPage)
+}
+
+// /, /av
+func getTopicsPage(c *gin.Context) {
+	uid := viewerUid(c)
+	offset := queryOffset(c)
+
+	var topics []Topic
+	err := queryTopics(&topics, uid, offset)
+	if err != nil {
+		renderError(c, err, 500, "server error")
+		return
+	}
+
+	page := htmlPage{}
+	page.Data = paginate(&page, topics, offset)
+
+	renderPage(c, 200, "topics", page)
+}
+
+// /cv
+func getModesPage(c *gin.Context) {
+	uid := viewerUid(c)
+
+	var modes []Mode
+	err := queryModes(&modes, uid)
+	if err != nil {
+		renderError(c, err, 500, "server error")
+		return
+	}
+
+	renderPage(c, 200, "modes", htmlPage{
+		Title: "版块",
+		Data:  modes,
+	})
+}
+
+// /av/:aid
+func getTopicAndPostsPage(c *gin.Context) {
+	uid := viewerUid(c)
+	aid, err := strconv.Atoi(c.Param("aid"))
+	if err != nil || aid <= 0 {
+		renderError(c, errors.New("invalid aid"), 404, "not found")
+		return
+	}
+
+	var res resAid
+	err, code, msg := queryTopicAndPosts(&res, uid, aid)
+	if err != nil {
+		renderError(c, err, code, msg)
+		return
+	}
+
+	renderPage(c, 200, "topic", htmlPage{
+		Title: res.Topic.Title,
+		Data:  res,
+	})
+}
+
+// /cv/:cid
+func getTopicsByModePage(c *gin.Context) {
+	uid := viewerUid(c)
+	cid, err := strconv.Atoi(c.Param("cid"))
+	if err != nil || cid <= 0 {
+		renderError(c, errors.New("invalid cid"), 404, "not found")
+		return
+	}
+	offset := queryOffset(c)
+
+	var res resCid
+	err, code, msg := queryTopicsByMode(&res, uid, cid, offset)
+	if err != nil {
+		renderError(c, err, code, msg)
+		return
+	}
+
+	page := htmlPage{
+		Title: res.Mode.Name,
+	}
+	res.Topics = paginate(&page, res.Topics, offset)
+	page.Data = res
+
+	renderPage(c, 200, "mode", page)
+}
+
+// /tag
+func getTagsPage(c *gin.Context) {
+	uid := viewerUid(c)
+
+	var tags []resTagCount
+	err := queryTags(&tags, uid)
+	if err != nil {
+		renderError(c, err, 500, "server error")
+		return
+	}
+
+	renderPage(c, 200, "tags", htmlPage{
+		Title: "标签",
+		Data:  tags,
+	})
+}
+
+// /tag/:name
+func getTopicsByTagPage(c *gin.Context) {
+	uid := viewerUid(c)
+	offset := queryOffset(c)
+
+	var res resTag
+	err, code, msg := queryTopicsByTag(&res, uid, c.Param("name"), offset)
+	if err != nil {
+		renderError(c, err, code, msg)
+		return
+	}
+
+	page := htmlPage{
+		Title: "#" + res.Tag.Name,
+	}
+	res.Topics = paginate(&page, res.Topics, offset)
+	page.Data = res
+
+	renderPage(c, 200, "tag", page)
+}
+
+func queryOffset(c *gin.Context) int {
+	var urlquery struct {
+		Offset int `form:"offset" binding:"min=0"`
+	}
+	if err := c.ShouldBindQuery(&urlquery); err != nil {
+		return 0
+	}
+
+	return urlquery.Offset
+}
+
+// 去掉 queryTopics 追加的 Id = -1 占位行，并计算前后页
+func paginate(page *htmlPage, topics []Topic, offset int) []Topic {
+	if len(topics) > pageSize && topics[pageSize].Id == -1 {
+		topics = topics[:pageSize]
+		page.HasNext = true
+		page.Next = offset + pageSize
+		page.NextUrl = "?offset=" + strconv.Itoa(page.Next)
+	}
+	if offset > 0 {
+		page.HasPrev = true
+		page.Prev = max(offset-pageSize, 0)
+		page.PrevUrl = "?offset=" + strconv.Itoa(page.Prev)
+	}
+
+	return topics
+}
+
+func renderPage(c *gin.Context, code int, name string, page htmlPage) {
+	c.Render(code, render.HTML{
+		Template: pages[name],
+		Name:     "layout",
+		Data:     page,
+	})
+}
+
+func renderError(c *gin.Context, err error, code int, msg string) {
+	log.Println("error:", err)
+
+	renderPage(c, code, "error", htmlPage{
+		Title: msg,
+		Data:  msg,
 	})
 }
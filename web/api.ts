@@ -2,24 +2,166 @@ import axios from "axios"
 
 export const base = ""
 
+type TopicStatus = "draft" | "scheduled" | "published"
+
 interface Topic {
     id: number
     created_at: number
     title: string
     mode_id: string
+    user_id: number
+    status: TopicStatus
+    publish_at?: string
+    tags?: string[]
+}
+
+interface Tag {
+    id: number
+    name: string
+}
+
+interface TagCount {
+    name: string
+    count: number
+}
+
+interface ResTag {
+    tag: Tag
+    topics: Topic[]
 }
 
 interface Post {
     topic_id: number
     floor: number
+    user_id: number
+    author?: string
+    status: PostStatus
     updated_at: string
     content: string
+    content_html: string
+}
+
+type PostStatus = "pending" | "approved"
+
+interface Pending {
+    id: number
+    topic_id: number
+    title: string
+    author: string
+    ip: string
+    content: string
+    updated_at: string
+}
+
+interface Spam {
+    id: number
+    topic_id: number
+    author: string
+    content: string
+    ip: string
+    reason: string
+    created_at: string
 }
 
 interface Mode {
     id: number
     name: string
     pub: boolean
+    guest: boolean
+}
+
+interface PostRevision {
+    id: number
+    topic_id: number
+    floor: number
+    created_at: string
+    content: string
+}
+
+interface ResDiff {
+    from: number
+    to: number
+    diff: string
+}
+
+interface Trash<T> {
+    item: T
+    deleted_at: string
+}
+
+interface ResTrash {
+    modes: Trash<Mode>[] | null
+    topics: Trash<Topic>[] | null
+    posts: Trash<Post>[] | null
+}
+
+type Role = "owner" | "editor" | "reader"
+
+interface Session {
+    id: string
+    user_id: number
+    ip: string
+    user_agent: string
+    created_at: string
+    expires_at: string
+    current: boolean
+}
+
+type Scope = "read:private" | "post:write" | "mode:admin"
+
+interface ApiToken {
+    id: number
+    user_id: number
+    name: string
+    prefix: string
+    scopes: string
+    created_at: string
+    expires_at: string | null
+    last_used_at: string | null
+    last_used_ip: string
+}
+
+interface User {
+    id: number
+    username: string
+    role: Role
+    created_at: string
+    totp: boolean
+}
+
+interface TotpSetup {
+    secret: string
+    uri: string
+}
+
+interface SearchResult {
+    id: number
+    title: string
+    mode_id: number
+    floor: number
+    snippet: string
+}
+
+interface File {
+    hash: string
+    name: string
+    mime: string
+    size: number
+    created_at: string
+}
+
+interface BackupStatus {
+    enabled: boolean
+    interval: string
+    daily: number
+    weekly: number
+    snapshots: number
+    last_at: string | null
+    last_ok_at: string | null
+    last_file: string
+    last_size: number
+    last_error: string
+    next_at: string | null
 }
 
 interface Result<T> {
@@ -57,10 +199,11 @@ req.interceptors.request.use(config => {
 req.interceptors.response.use(response => response.data)
 
 export const search = (
-    q: string
-): Result<Topic> => {
+    q: string,
+    offset?: number
+): Promise<Result<SearchResult[]>> => {
     return req.get("/search", {
-        params: {q}
+        params: offset != null ? {q, offset} : {q}
     })
 }
 
@@ -91,13 +234,28 @@ export const reqCid = (
     })
 }
 
+export const reqTag = (): Promise<Result<TagCount[]>> => {
+    return req.get("/tag")
+}
+
+export const reqTagTopics = (
+    name: string,
+    offset?: number
+): Promise<Result<ResTag>> => {
+    return req.get("/tag/" + encodeURIComponent(name), {
+        params: offset != null ? {offset} : undefined
+    })
+}
+
 export const createCv = (
     name: string,
-    deep: number
+    deep: number,
+    guest?: boolean
 ): Promise<Result<Mode>> => {
     return req.post("/cv/create", {
         name,
-        deep
+        deep,
+        guest
     })
 }
 
@@ -112,24 +270,32 @@ export const deleteCv = (
 export const updateCv = (
     id: number,
     name: string,
-    deep: number
+    deep: number,
+    guest?: boolean
 ): Promise<Result<Mode>> => {
     return req.post("/cv/update", {
         id,
         name,
-        deep
+        deep,
+        guest
     })
 }
 
 export const createAv = (
     title: string,
     mode_id: number,
-    content: string
+    content: string,
+    status?: TopicStatus,
+    publish_at?: string,
+    tags?: string[]
 ): Promise<Result<ResAid>> => {
     return req.post("/av/create", {
         title,
         mode_id,
-        content
+        content,
+        status,
+        publish_at,
+        tags
     })
 }
 
@@ -143,13 +309,19 @@ export const deleteAv = (
 
 export const updateAv = (
     id: number,
-    title: string,
-    mode_id: number
+    title?: string,
+    mode_id?: number,
+    status?: TopicStatus,
+    publish_at?: string,
+    tags?: string[]
 ): Promise<Result<Topic>> => {
     return req.post("/av/update", {
         id,
         title,
-        mode_id
+        mode_id,
+        status,
+        publish_at,
+        tags
     })
 }
 
@@ -163,6 +335,71 @@ export const createFl = (
     })
 }
 
+export const reqFormToken = (
+    topic_id: number
+): Promise<Result<string>> => {
+    return req.get("/fl/token", {
+        params: {topic_id}
+    })
+}
+
+// website 为蜜罐字段，表单中应隐藏且留空
+export const createGuestFl = (
+    topic_id: number,
+    content: string,
+    token: string,
+    author?: string,
+    website?: string
+): Promise<Result<Post>> => {
+    return req.post("/fl/guest", {
+        topic_id,
+        author,
+        content,
+        token,
+        website
+    })
+}
+
+export const reqPending = (): Promise<Result<Pending[]>> => {
+    return req.get("/moderation")
+}
+
+export const approveFl = (
+    ids: number[]
+): Promise<Result<Post[]>> => {
+    return req.post("/moderation/approve", {
+        ids
+    })
+}
+
+export const rejectFl = (
+    ids: number[]
+): Promise<Result<number>> => {
+    return req.post("/moderation/reject", {
+        ids
+    })
+}
+
+export const reqSpam = (): Promise<Result<Spam[]>> => {
+    return req.get("/moderation/spam")
+}
+
+export const releaseSpam = (
+    ids: number[]
+): Promise<Result<Post[]>> => {
+    return req.post("/moderation/spam/release", {
+        ids
+    })
+}
+
+export const deleteSpam = (
+    ids: number[]
+): Promise<Result<number>> => {
+    return req.post("/moderation/spam/delete", {
+        ids
+    })
+}
+
 export const deleteFl = (
     topic_id: number,
     floor: number
@@ -185,22 +422,233 @@ export const updateFl = (
     })
 }
 
+export const reqRevision = (
+    topic_id: number,
+    floor: number
+): Promise<Result<PostRevision[]>> => {
+    return req.get("/fl/revision", {
+        params: {topic_id, floor}
+    })
+}
+
+export const reqDiff = (
+    topic_id: number,
+    floor: number,
+    from: number,
+    to: number
+): Promise<Result<ResDiff>> => {
+    return req.get("/fl/diff", {
+        params: {topic_id, floor, from, to}
+    })
+}
+
+export const restoreFl = (
+    topic_id: number,
+    floor: number,
+    id: number
+): Promise<Result<Post>> => {
+    return req.post("/fl/restore", {
+        topic_id,
+        floor,
+        id
+    })
+}
+
+export const reqTrash = (): Promise<Result<ResTrash>> => {
+    return req.get("/trash")
+}
+
+export const restoreCv = (
+    id: number
+): Promise<Result<Mode>> => {
+    return req.post("/trash/cv/restore", {
+        id
+    })
+}
+
+export const restoreAv = (
+    id: number
+): Promise<Result<Topic>> => {
+    return req.post("/trash/av/restore", {
+        id
+    })
+}
+
+export const restoreDeletedFl = (
+    topic_id: number,
+    floor: number
+): Promise<Result<Post>> => {
+    return req.post("/trash/fl/restore", {
+        topic_id,
+        floor
+    })
+}
+
+export const purgeTrash = (
+    days: number
+): Promise<Result<void>> => {
+    return req.post("/trash/purge", {
+        days
+    })
+}
+
 export const reqSpace = (): Promise<Result<boolean>> => {
     return req.get("/space")
 }
 
+// 开启两步验证时先返回 msg "totp required"，再附带 code 重新登录
 export const login = (
-    password: string
+    username: string,
+    password: string,
+    code?: string
 ): Promise<Result<string>> => {
     return req.post("/login", {
-        password
+        username,
+        password,
+        code
     })
 }
 
+// 修改密码会吊销全部会话，返回当前客户端的新 token
 export const changeAuth = (
     password: string
-): Promise<Result<void>> => {
+): Promise<Result<string>> => {
     return req.post("/auth/change", {
         password
     })
 }
+
+export const logout = (): Promise<Result<void>> => {
+    return req.post("/auth/logout")
+}
+
+export const reqSessions = (): Promise<Result<Session[]>> => {
+    return req.get("/auth/session")
+}
+
+export const setupTotp = (): Promise<Result<TotpSetup>> => {
+    return req.post("/auth/totp/setup")
+}
+
+// 返回一次性恢复码
+export const enableTotp = (
+    code: string
+): Promise<Result<string[]>> => {
+    return req.post("/auth/totp/enable", {
+        code
+    })
+}
+
+export const disableTotp = (
+    password: string
+): Promise<Result<void>> => {
+    return req.post("/auth/totp/disable", {
+        password
+    })
+}
+
+export const reqApiTokens = (): Promise<Result<ApiToken[]>> => {
+    return req.get("/auth/token")
+}
+
+// token 明文仅在创建时返回一次
+export const createApiToken = (
+    name: string,
+    scopes: Scope[],
+    expires_at?: string
+): Promise<Result<{token: string, info: ApiToken}>> => {
+    return req.post("/auth/token/create", {
+        name,
+        scopes,
+        expires_at
+    })
+}
+
+export const deleteApiToken = (
+    id: number
+): Promise<Result<void>> => {
+    return req.post("/auth/token/delete", {
+        id
+    })
+}
+
+export const rotateKey = (): Promise<Result<number>> => {
+    return req.post("/auth/rotate")
+}
+
+export const reqBackupStatus = (): Promise<Result<BackupStatus>> => {
+    return req.get("/backup")
+}
+
+// 返回 tar.gz 压缩包
+export const reqExport = (): Promise<Blob> => {
+    return req.get("/export", {
+        responseType: "blob"
+    })
+}
+
+export const revokeSession = (
+    id: string
+): Promise<Result<void>> => {
+    return req.post("/auth/session/revoke", {
+        id
+    })
+}
+
+export const upload = (
+    file: Blob
+): Promise<Result<File>> => {
+    const form = new FormData()
+    form.append("file", file)
+    return req.post("/upload", form, {
+        headers: {
+            "Content-Type": "multipart/form-data"
+        }
+    })
+}
+
+export const fileUrl = (
+    hash: string
+): string => {
+    return base + "/file/" + hash
+}
+
+export const reqMe = (): Promise<Result<User>> => {
+    return req.get("/user/me")
+}
+
+export const reqUsers = (): Promise<Result<User[]>> => {
+    return req.get("/user")
+}
+
+export const createUser = (
+    username: string,
+    password: string,
+    role: Role
+): Promise<Result<User>> => {
+    return req.post("/user/create", {
+        username,
+        password,
+        role
+    })
+}
+
+export const updateUser = (
+    id: number,
+    password?: string,
+    role?: Role
+): Promise<Result<User>> => {
+    return req.post("/user/update", {
+        id,
+        password,
+        role
+    })
+}
+
+export const deleteUser = (
+    id: number
+): Promise<Result<void>> => {
+    return req.post("/user/delete", {
+        id
+    })
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPaginate(t *testing.T) {
+	full := make([]Topic, pageSize+1)
+	full[pageSize].Id = -1
+
+	tests := []struct {
+		name    string
+		topics  []Topic
+		offset  int
+		wantLen int
+		prevUrl string
+		nextUrl string
+	}{
+		{"single page", make([]Topic, 3), 0, 3, "", ""},
+		{"has next", full, 0, pageSize, "", "?offset=" + strconv.Itoa(pageSize)},
+		{"middle", full, pageSize, pageSize, "?offset=0", "?offset=" + strconv.Itoa(2*pageSize)},
+		{"last", make([]Topic, 1), pageSize, 1, "?offset=0", ""},
+		{"odd offset", nil, 5, 0, "?offset=0", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := htmlPage{}
+			got := paginate(&page, tt.topics, tt.offset)
+			if len(got) != tt.wantLen || page.PrevUrl != tt.prevUrl || page.NextUrl != tt.nextUrl {
+				t.Errorf("got %d topics, prev %q, next %q", len(got), page.PrevUrl, page.NextUrl)
+			}
+			if page.HasPrev != (tt.prevUrl != "") || page.HasNext != (tt.nextUrl != "") {
+				t.Errorf("HasPrev %v, HasNext %v", page.HasPrev, page.HasNext)
+			}
+		})
+	}
+}
+
+// 游客只能看到公开版块中已发布的主题
+func TestRenderHtml(t *testing.T) {
+	err := db.AutoMigrate(&Tag{}, &TopicTag{}, &User{}, &Session{}, &ApiToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub := newTestTopic(t, true)
+	err = (&Post{TopicId: pub.Id, Content: "**bold** <script>x</script>"}).create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	private := newTestTopic(t, false)
+
+	r := gin.New()
+	h := r.Group("/")
+	h.Use(authMiddleware())
+	renderHtml(h)
+
+	tests := []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/", 200, "<html"},
+		{"/av", 200, "<html"},
+		{"/av/" + strconv.Itoa(pub.Id), 200, "<strong>bold</strong>"},
+		{"/av/" + strconv.Itoa(private.Id), 404, "not found"},
+		{"/av/abc", 404, "not found"},
+		{"/av/0", 404, "not found"},
+		{"/cv", 200, "<html"},
+		{"/cv/" + strconv.Itoa(pub.ModeId), 200, pub.Title},
+		{"/cv/" + strconv.Itoa(private.ModeId), 404, "not found"},
+		{"/tag", 200, "<html"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.code {
+				t.Errorf("status %d, want %d", w.Code, tt.code)
+			}
+			body := w.Body.String()
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("body does not contain %q", tt.want)
+			}
+			if strings.Contains(body, "<script>x") {
+				t.Error("raw html in post content")
+			}
+		})
+	}
+}